@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib"
+)
+
+// checkpoint records the progress of a scan so that it can be resumed with -resume.
+type checkpoint struct {
+	Instrument string `json:"instrument"`
+	Period     string `json:"period"`
+	Search     string `json:"search"` // search conditions which must not change on resume
+	// LastCompleted is the time of the last snapshot scanned. Every snapshot before it has been scanned too.
+	LastCompleted time.Time `json:"lastCompleted"`
+	// OutputSize is the size of the output file when the checkpoint is saved.
+	// Records written after it are discarded on resume because they are scanned again.
+	OutputSize int64 `json:"outputSize"`
+}
+
+// matches reports whether the checkpoint was saved by a scan with the same conditions.
+func (c *checkpoint) matches(instrument, period, search string) error {
+	if c.Instrument != instrument || c.Period != period || c.Search != search {
+		return fmt.Errorf("checkpoint is saved by another scan (instrument: %s, period: %s, search: %s)", c.Instrument, c.Period, c.Search)
+	}
+	return nil
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	var c checkpoint
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, fmt.Errorf("failed to json unmarshal checkpoint: %v", err)
+	}
+	return &c, nil
+}
+
+// saveCheckpoint writes the checkpoint. A crash while saving never breaks the previous checkpoint.
+func saveCheckpoint(path string, c *checkpoint) error {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to json marshal checkpoint: %v", err)
+	}
+	if err := lib.WriteFileAtomic(path, body, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ob-search.csv.checkpoint")
+
+	limits := searchLimits{stopOrder: []float64{0.5, 1.0}}
+	cp := &checkpoint{
+		Instrument:    "USD_JPY",
+		Period:        "2020/10/01-2020/10/02",
+		Search:        limits.String(),
+		LastCompleted: time.Date(2020, 10, 1, 0, 40, 0, 0, time.UTC),
+		OutputSize:    1234,
+	}
+	if err := saveCheckpoint(path, cp); err != nil {
+		t.Fatalf("saveCheckpoint() error = %v", err)
+	}
+
+	loaded, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("loadCheckpoint() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded, cp) {
+		t.Errorf("loadCheckpoint() = %+v, want %+v", loaded, cp)
+	}
+	if err := loaded.matches("USD_JPY", "2020/10/01-2020/10/02", limits.String()); err != nil {
+		t.Errorf("matches() error = %v", err)
+	}
+	other := searchLimits{stopOrder: []float64{0.8}}
+	if err := loaded.matches("USD_JPY", "2020/10/01-2020/10/02", other.String()); err == nil {
+		t.Errorf("matches() error = nil, want mismatch of search")
+	}
+}
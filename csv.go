@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/search"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// recordWriter streams matches to the output in CSV. A match is written as a record.
+type recordWriter struct {
+	w   *csv.Writer
+	loc string
+	// instrument writes the instrument after the price
+	instrument bool
+	// searchName writes the name of the search after the price
+	searchName bool
+	// net writes net amounts of buckets instead of percentages
+	net bool
+}
+
+// newRecordWriter constructs recordWriter formatting date time in loc.
+// The output is encoded in Shift_JIS for Excel if jp is true.
+func newRecordWriter(f io.Writer, jp bool, loc string) *recordWriter {
+	w := csv.NewWriter(f)
+	if jp {
+		w = csv.NewWriter(transform.NewWriter(f, japanese.ShiftJIS.NewEncoder()))
+	}
+	return &recordWriter{w: w, loc: loc}
+}
+
+// recordHeader returns the header of a record and of a bucket in it.
+// Buckets have net amounts instead of percentages if net is true. See README for the schema.
+func recordHeader(loc string, instrument, searchName, net bool) (baseHeader, bucketHeader []string) {
+	baseHeader = []string{fmt.Sprintf("date-time (%s)", loc), "price"}
+	if instrument {
+		baseHeader = append(baseHeader, "instrument")
+	}
+	if searchName {
+		baseHeader = append(baseHeader, "search-name")
+	}
+	baseHeader = append(baseHeader, "search-kind", "side", "distance-pips", "offset", "conditions")
+	if net {
+		return baseHeader, []string{"price-range", "net-order", "net-position"}
+	}
+	bucketHeader = []string{"price-range", "short-order", "long-order", "short-position", "long-position"}
+	return baseHeader, bucketHeader
+}
+
+// WriteHeader writes the header of matches with up to bucketHeaderMaxSize buckets.
+func (w *recordWriter) WriteHeader(baseHeader, bucketHeader []string, bucketHeaderMaxSize int) error {
+	header := baseHeader
+	for i := 0; i < bucketHeaderMaxSize; i++ {
+		var h []string
+		for _, s := range bucketHeader {
+			h = append(h, fmt.Sprintf("%s-%d", s, i))
+		}
+		header = append(header, h...)
+	}
+	return w.w.Write(header)
+}
+
+// Write writes matches. They are buffered until Flush.
+func (w *recordWriter) Write(matches []search.Match) error {
+	for _, m := range matches {
+		var bucketRecord []string
+		for _, b := range m.Buckets {
+			bucketRecord = append(bucketRecord, b.Price.PriceStr(m.Instrument))
+			if w.net {
+				bucketRecord = append(bucketRecord, strconv.FormatFloat(b.NetOrder(), 'f', 2, 64))
+				bucketRecord = append(bucketRecord, strconv.FormatFloat(b.NetPosition(), 'f', 2, 64))
+				continue
+			}
+			bucketRecord = append(bucketRecord, strconv.FormatFloat(b.ShortOrder, 'f', 2, 64))
+			bucketRecord = append(bucketRecord, strconv.FormatFloat(b.LongOrder, 'f', 2, 64))
+			bucketRecord = append(bucketRecord, strconv.FormatFloat(b.ShortPosition, 'f', 2, 64))
+			bucketRecord = append(bucketRecord, strconv.FormatFloat(b.LongPosition, 'f', 2, 64))
+		}
+		record := []string{timeString(m.Time, w.loc), m.Price.PriceStr(m.Instrument)}
+		if w.instrument {
+			record = append(record, string(m.Instrument))
+		}
+		if w.searchName {
+			record = append(record, m.Search)
+		}
+		distance, offset := "", ""
+		if d, ok := m.DistancePips(); ok {
+			distance = strconv.FormatFloat(float64(d), 'f', 1, 64)
+			offset = strconv.Itoa(m.Offset)
+		}
+		record = append(record, m.Kind, m.Side.String(), distance, offset, strings.Join(m.Conditions, " "))
+		if err := w.w.Write(append(record, bucketRecord...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the output.
+func (w *recordWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
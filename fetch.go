@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/archive"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+// runFetch downloads order books and position books into a local archive.
+// Snapshots already in the archive or known to be missing on OANDA are skipped, so an
+// interrupted download can be resumed by running the same command again.
+func runFetch(args []string) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	clientFlags := registerClientFlags(fs)
+	periodStr := fs.String("period", "", "specify the period to download.")
+	instrumentStr := fs.String("instrument", "", "specify comma separated instruments.")
+	outDir := fs.String("out", "archive", "directory of the archive.")
+	_ = fs.Parse(args) // exits on error
+
+	since, until, err := parsePeriod(*periodStr)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	client, err := clientFlags.newClient()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	clientFlags.registerInstruments(context.Background(), client)
+	instruments, err := parseInstruments(*instrumentStr)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	a := archive.New(*outDir)
+	m, err := a.LoadManifest()
+	if err != nil {
+		log.Fatalf("failed to load manifest: %v", err)
+		return
+	}
+	stats := fetchArchive(ctx, client, a, m, instruments, since, until)
+	if err := a.SaveManifest(m); err != nil {
+		log.Fatalf("failed to save manifest: %v", err)
+		return
+	}
+	log.Printf("fetched: %d, skipped: %d, gaps: %d, failures: %d (see %s/manifest.json)",
+		stats.fetched, stats.skipped, len(m.Gaps), len(m.Failures), a.Dir())
+}
+
+type fetchStats struct {
+	fetched int
+	skipped int
+}
+
+// fetchArchive downloads snapshots of books from since until until every 20 minutes into
+// the archive and records missing ones in the manifest. It stops when ctx is done.
+func fetchArchive(ctx context.Context, client *oanda.Client, a *archive.Archive, m *archive.Manifest,
+	instruments []oanda.Instrument, since, until time.Time) fetchStats {
+	var stats fetchStats
+	const twentyMinutes = 20 * time.Minute
+	const snapshotsPerDay = 72
+	for _, instrument := range instruments {
+		n := 0
+		for t := since; t.Before(until) && ctx.Err() == nil; t = t.Add(twentyMinutes) {
+			for _, kind := range []oanda.BookKind{oanda.OrderBook, oanda.PositionBook} {
+				if a.Has(instrument, kind, t) || m.IsGap(instrument, kind, t) {
+					stats.skipped++
+					continue
+				}
+				stored, err := fetchSnapshot(ctx, client, a, m, instrument, kind, t)
+				if err != nil {
+					if ctx.Err() != nil {
+						return stats
+					}
+					log.Printf("failed to fetch %s of %s (at %s): %v", kind, instrument, t.String(), err)
+					m.AddFailure(instrument, kind, t, err.Error())
+					continue
+				}
+				if stored {
+					stats.fetched++
+				}
+			}
+			// save the manifest every day so that a crash loses little
+			if n++; n%snapshotsPerDay == 0 {
+				if err := a.SaveManifest(m); err != nil {
+					log.Printf("failed to save manifest: %v", err)
+				}
+			}
+		}
+	}
+	return stats
+}
+
+// fetchSnapshot downloads the snapshot at t and reports whether it is stored.
+// Snapshots which OANDA does not have are recorded as gaps once they are settled. Until then
+// they may not be published yet, so that they fail to be fetched and are retried by the next run.
+func fetchSnapshot(ctx context.Context, client *oanda.Client, a *archive.Archive, m *archive.Manifest,
+	instrument oanda.Instrument, kind oanda.BookKind, t time.Time) (bool, error) {
+	settled := oanda.Settled(t, time.Now())
+	body, err := client.FetchBookJSONContext(ctx, kind, instrument, &t)
+	var e *oanda.Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusNotFound {
+		if !settled {
+			return false, errors.New("not published yet: not found")
+		}
+		m.AddGap(instrument, kind, t, "not found")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	book, err := oanda.ParseBook(kind, body)
+	if err != nil {
+		return false, err
+	}
+	// OANDA responds with the latest snapshot before t if it does not have the snapshot at t
+	if !book.Time.Equal(t) {
+		if !settled {
+			return false, fmt.Errorf("not published yet: responded with the snapshot at %s", book.Time.UTC().Format(time.RFC3339))
+		}
+		m.AddGap(instrument, kind, t, fmt.Sprintf("responded with the snapshot at %s", book.Time.UTC().Format(time.RFC3339)))
+		return false, nil
+	}
+	if err := a.Write(instrument, kind, t, body); err != nil {
+		return false, err
+	}
+	m.Resolve(instrument, kind, t)
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/archive"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda/oandatest"
+)
+
+func TestFetchArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	since := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	srv := oandatest.NewServer()
+	defer srv.Close()
+	for i := 0; i < 3; i++ {
+		at := since.Add(time.Duration(i) * 20 * time.Minute)
+		srv.AddOrderBook(oandatest.NewBook("USD_JPY", at, "105.512", "0.05", 3))
+		if i != 1 {
+			srv.AddPositionBook(oandatest.NewBook("USD_JPY", at, "105.512", "0.05", 3))
+		}
+	}
+	srv.FailNext(1, 500, "")
+
+	client := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.NoRetry))
+	a := archive.New(dir)
+	m, err := a.LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	until := since.Add(time.Hour)
+	stats := fetchArchive(context.Background(), client, a, m, []oanda.Instrument{oanda.InstrumentUSDJPY}, since, until)
+	if stats.fetched != 4 || stats.skipped != 0 {
+		t.Errorf("fetchArchive() = %+v, want 4 fetched", stats)
+	}
+	if len(m.Failures) != 1 || !m.Failures[0].Time.Equal(since) || m.Failures[0].Kind != oanda.OrderBook {
+		t.Errorf("Failures = %+v, want the order book at %s", m.Failures, since)
+	}
+	if len(m.Gaps) != 1 || !m.Gaps[0].Time.Equal(since.Add(20*time.Minute)) || m.Gaps[0].Kind != oanda.PositionBook {
+		t.Errorf("Gaps = %+v, want the position book at 00:20", m.Gaps)
+	}
+	if err := a.SaveManifest(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// resume fetches the failed snapshot only
+	requests := len(srv.Requests())
+	m, err = a.LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats = fetchArchive(context.Background(), client, a, m, []oanda.Instrument{oanda.InstrumentUSDJPY}, since, until)
+	if stats.fetched != 1 || stats.skipped != 5 {
+		t.Errorf("fetchArchive() = %+v, want 1 fetched and 5 skipped", stats)
+	}
+	if n := len(srv.Requests()) - requests; n != 1 {
+		t.Errorf("fetchArchive() sent %d requests, want 1", n)
+	}
+	if len(m.Failures) != 0 {
+		t.Errorf("Failures = %+v, want none", m.Failures)
+	}
+	body, err := a.Read(oanda.InstrumentUSDJPY, oanda.OrderBook, since)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if book, err := oanda.ParseOrderBook(body); err != nil || !book.Time.Equal(since) {
+		t.Errorf("ParseOrderBook() = %v, %v", book, err)
+	}
+}
+
+func TestFetchArchive_notPublished(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the snapshot of 20 minutes ago is not published yet
+	latest := time.Now().UTC().Truncate(20 * time.Minute).Add(-20 * time.Minute)
+	since := latest.Add(-2 * time.Hour)
+	srv := oandatest.NewServer()
+	defer srv.Close()
+	for at := since; at.Before(latest); at = at.Add(20 * time.Minute) {
+		srv.AddOrderBook(oandatest.NewBook("USD_JPY", at, "105.512", "0.05", 3))
+		srv.AddPositionBook(oandatest.NewBook("USD_JPY", at, "105.512", "0.05", 3))
+	}
+
+	client := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.NoRetry))
+	a := archive.New(dir)
+	m, err := a.LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	until := latest.Add(20 * time.Minute)
+	stats := fetchArchive(context.Background(), client, a, m, []oanda.Instrument{oanda.InstrumentUSDJPY}, since, until)
+	if stats.fetched != 12 {
+		t.Errorf("fetchArchive() = %+v, want 12 fetched", stats)
+	}
+	if len(m.Gaps) != 0 {
+		t.Errorf("Gaps = %+v, want none", m.Gaps)
+	}
+	if len(m.Failures) != 2 || !m.Failures[0].Time.Equal(latest) || !m.Failures[1].Time.Equal(latest) {
+		t.Errorf("Failures = %+v, want both books at %s", m.Failures, latest)
+	}
+	if err := a.SaveManifest(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next run tries the snapshot again once it is published
+	srv.AddOrderBook(oandatest.NewBook("USD_JPY", latest, "105.512", "0.05", 3))
+	srv.AddPositionBook(oandatest.NewBook("USD_JPY", latest, "105.512", "0.05", 3))
+	requests := len(srv.Requests())
+	if m, err = a.LoadManifest(); err != nil {
+		t.Fatal(err)
+	}
+	stats = fetchArchive(context.Background(), client, a, m, []oanda.Instrument{oanda.InstrumentUSDJPY}, since, until)
+	if stats.fetched != 2 || stats.skipped != 12 {
+		t.Errorf("fetchArchive() = %+v, want 2 fetched and 12 skipped", stats)
+	}
+	if n := len(srv.Requests()) - requests; n != 2 {
+		t.Errorf("fetchArchive() sent %d requests, want 2", n)
+	}
+	if len(m.Gaps) != 0 || len(m.Failures) != 0 {
+		t.Errorf("manifest = %+v, want neither gaps nor failures", m)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+// clientFlags are the flags to build oanda.Client shared by the subcommands.
+type clientFlags struct {
+	key       *string
+	account   *string
+	env       *string
+	url       *string
+	rateLimit *float64
+	burst     *int
+	cacheDir  *string
+	timeout   *time.Duration
+}
+
+func registerClientFlags(fs *flag.FlagSet) *clientFlags {
+	return &clientFlags{
+		key:       fs.String("oanda-key", "", "oanda API key"),
+		account:   fs.String("oanda-account", "", "oanda account ID whose instruments are searched in addition to the built-in instruments."),
+		env:       fs.String("oanda-env", "practice", "oanda environment (practice or trade)."),
+		url:       fs.String("oanda-url", "", "base URL of oanda API which overrides oanda-env."),
+		rateLimit: fs.Float64("rate-limit", oanda.DefaultRateLimit, "maximum number of OANDA API requests per second."),
+		burst:     fs.Int("burst", oanda.DefaultBurst, "number of OANDA API requests which can be sent at once."),
+		cacheDir:  fs.String("cache-dir", "", "directory to cache historical books in. books are not cached if empty."),
+		timeout:   fs.Duration("timeout", oanda.DefaultTimeout, "time limit of each OANDA API request."),
+	}
+}
+
+// newClient validates the flags and builds oanda.Client.
+func (f *clientFlags) newClient() (*oanda.Client, error) {
+	if len(*f.key) == 0 {
+		return nil, fmt.Errorf("oanda-key is required")
+	}
+	env, err := oanda.ParseEnvironment(*f.env)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oanda-env: %v", err)
+	}
+	opts := []oanda.Option{
+		oanda.WithEnvironment(env),
+		oanda.WithRateLimit(*f.rateLimit, *f.burst),
+		oanda.WithTimeout(*f.timeout),
+	}
+	if len(*f.url) > 0 {
+		opts = append(opts, oanda.WithBaseURL(*f.url))
+	}
+	if len(*f.cacheDir) > 0 {
+		opts = append(opts, oanda.WithCache(oanda.NewCache(*f.cacheDir)))
+	}
+	return oanda.NewClient(*f.key, opts...), nil
+}
+
+// registerInstruments registers the instruments of oanda-account fetched through client so that
+// instruments other than the built-in ones can be searched. The built-in instruments are used
+// alone if oanda-account is empty or the instruments can not be fetched.
+func (f *clientFlags) registerInstruments(ctx context.Context, client *oanda.Client) {
+	if len(*f.account) == 0 {
+		return
+	}
+	specs, err := client.FetchInstrumentsContext(ctx, *f.account)
+	if err != nil {
+		log.Printf("failed to fetch instruments of %s, the built-in instruments are used: %v", *f.account, err)
+		return
+	}
+	oanda.Register(specs...)
+}
+
+// parsePeriod parses period such as 2020/10/01-2020/11/01.
+func parsePeriod(str string) (since, until time.Time, err error) {
+	if len(str) == 0 {
+		return since, until, fmt.Errorf("period is required")
+	}
+	period := strings.Split(str, "-")
+	if len(period) != 2 {
+		return since, until, fmt.Errorf("invalid period: %s (ex: 2020/10/01-2020/11/01)", str)
+	}
+	layout := "2006/01/02"
+	since, err = time.Parse(layout, period[0])
+	if err != nil {
+		return since, until, fmt.Errorf("failed to parse period to date time: %v", period[0])
+	}
+	until, err = time.Parse(layout, period[1])
+	if err != nil {
+		return since, until, fmt.Errorf("failed to parse period to date time: %v", period[1])
+	}
+	return since, until, nil
+}
+
+// signalContext returns a context which is canceled on SIGINT or SIGTERM.
+// A second signal terminates the process immediately.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-sig:
+			log.Printf("received %s: stop scanning", s)
+			signal.Stop(sig)
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(sig)
+		}
+	}()
+	return ctx, cancel
+}
+
+// parseInstruments parses comma separated instruments such as USD_JPY,EUR_USD.
+func parseInstruments(str string) ([]oanda.Instrument, error) {
+	if len(str) == 0 {
+		return nil, fmt.Errorf("instrument is required")
+	}
+	return toInstruments(strings.Split(str, ","))
+}
+
+// toInstruments converts the names of instruments. all is the major instruments of oanda.Instruments.
+// An instrument given twice is used once.
+func toInstruments(names []string) ([]oanda.Instrument, error) {
+	var instruments []oanda.Instrument
+	seen := map[oanda.Instrument]bool{}
+	for _, s := range names {
+		s = strings.TrimSpace(s)
+		named := []oanda.Instrument{oanda.ToInstrument(s)}
+		if s == "all" {
+			named = oanda.Instruments()
+		}
+		for _, instrument := range named {
+			if instrument == oanda.InstrumentUNKNOWN {
+				return nil, fmt.Errorf("invalid instrument: %s", s)
+			}
+			if seen[instrument] {
+				continue
+			}
+			seen[instrument] = true
+			instruments = append(instruments, instrument)
+		}
+	}
+	return instruments, nil
+}
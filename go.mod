@@ -5,4 +5,5 @@ go 1.14
 require (
 	github.com/aws/aws-sdk-go v1.35.33
 	golang.org/x/text v0.3.4
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,190 @@
+// Package archive stores books downloaded from OANDA API in a local directory.
+//
+// Each snapshot is stored as it is responded by OANDA API in
+// {dir}/{instrument}/{kind}/{2006-01-02}/{150405}.json (UTC), and snapshots which could
+// not be stored are listed in {dir}/manifest.json.
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+const manifestName = "manifest.json"
+
+// Archive is a directory of books.
+type Archive struct {
+	dir string
+}
+
+// New constructs Archive of dir. The directory is created on the first write.
+func New(dir string) *Archive {
+	return &Archive{dir: dir}
+}
+
+// Dir returns the root directory of the archive.
+func (a *Archive) Dir() string {
+	return a.dir
+}
+
+// Path returns the file path of the snapshot.
+func (a *Archive) Path(instrument oanda.Instrument, kind oanda.BookKind, t time.Time) string {
+	t = t.UTC()
+	return filepath.Join(a.dir, string(instrument), string(kind), t.Format("2006-01-02"), t.Format("150405")+".json")
+}
+
+// Has reports whether the snapshot is stored.
+func (a *Archive) Has(instrument oanda.Instrument, kind oanda.BookKind, t time.Time) bool {
+	_, err := os.Stat(a.Path(instrument, kind, t))
+	return err == nil
+}
+
+// Read returns the stored snapshot. The error satisfies os.IsNotExist if it is not stored.
+func (a *Archive) Read(instrument oanda.Instrument, kind oanda.BookKind, t time.Time) ([]byte, error) {
+	return ioutil.ReadFile(a.Path(instrument, kind, t))
+}
+
+// Write stores the snapshot. An interrupted download never leaves a partial file.
+func (a *Archive) Write(instrument oanda.Instrument, kind oanda.BookKind, t time.Time, body []byte) error {
+	return writeFile(a.Path(instrument, kind, t), body)
+}
+
+// writeFile writes body into the file at path by lib.WriteFileAtomic.
+func writeFile(path string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := lib.WriteFileAtomic(path, body, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	return nil
+}
+
+// Entry is a snapshot which is not stored in the archive.
+type Entry struct {
+	Instrument oanda.Instrument `json:"instrument"`
+	Kind       oanda.BookKind   `json:"kind"`
+	Time       time.Time        `json:"time"`
+	Reason     string           `json:"reason"`
+}
+
+type entryKey struct {
+	instrument oanda.Instrument
+	kind       oanda.BookKind
+	time       int64
+}
+
+func keyOf(instrument oanda.Instrument, kind oanda.BookKind, t time.Time) entryKey {
+	return entryKey{instrument, kind, t.Unix()}
+}
+
+// Manifest lists snapshots missing from the archive.
+// Gaps are snapshots which OANDA does not have (e.g. while the market is closed) and are
+// never fetched again, failures are snapshots which failed to be fetched and are retried.
+type Manifest struct {
+	UpdatedAt time.Time `json:"updatedAt"`
+	Gaps      []Entry   `json:"gaps"`
+	Failures  []Entry   `json:"failures"`
+
+	gaps     map[entryKey]int
+	failures map[entryKey]int
+}
+
+// LoadManifest reads the manifest of the archive. An empty manifest is returned if it does not exist.
+func (a *Archive) LoadManifest() (*Manifest, error) {
+	m := &Manifest{}
+	body, err := ioutil.ReadFile(filepath.Join(a.dir, manifestName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(body, m); err != nil {
+			return nil, fmt.Errorf("failed to json unmarshal manifest: %v", err)
+		}
+	}
+	m.index()
+	return m, nil
+}
+
+// SaveManifest writes the manifest to the archive.
+func (a *Archive) SaveManifest(m *Manifest) error {
+	m.UpdatedAt = time.Now().UTC()
+	m.sort()
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal manifest: %v", err)
+	}
+	return writeFile(filepath.Join(a.dir, manifestName), body)
+}
+
+func (m *Manifest) index() {
+	m.gaps = map[entryKey]int{}
+	for i, e := range m.Gaps {
+		m.gaps[keyOf(e.Instrument, e.Kind, e.Time)] = i
+	}
+	m.failures = map[entryKey]int{}
+	for i, e := range m.Failures {
+		m.failures[keyOf(e.Instrument, e.Kind, e.Time)] = i
+	}
+}
+
+func (m *Manifest) sort() {
+	less := func(entries []Entry) func(i, j int) bool {
+		return func(i, j int) bool {
+			a, b := entries[i], entries[j]
+			if a.Instrument != b.Instrument {
+				return a.Instrument < b.Instrument
+			}
+			if !a.Time.Equal(b.Time) {
+				return a.Time.Before(b.Time)
+			}
+			return a.Kind < b.Kind
+		}
+	}
+	sort.Slice(m.Gaps, less(m.Gaps))
+	sort.Slice(m.Failures, less(m.Failures))
+	m.index()
+}
+
+// IsGap reports whether the snapshot is known to be missing on OANDA.
+func (m *Manifest) IsGap(instrument oanda.Instrument, kind oanda.BookKind, t time.Time) bool {
+	_, ok := m.gaps[keyOf(instrument, kind, t)]
+	return ok
+}
+
+// AddGap records the snapshot as missing on OANDA.
+func (m *Manifest) AddGap(instrument oanda.Instrument, kind oanda.BookKind, t time.Time, reason string) {
+	m.Resolve(instrument, kind, t)
+	m.gaps[keyOf(instrument, kind, t)] = len(m.Gaps)
+	m.Gaps = append(m.Gaps, Entry{instrument, kind, t.UTC(), reason})
+}
+
+// AddFailure records the snapshot as failed to be fetched.
+func (m *Manifest) AddFailure(instrument oanda.Instrument, kind oanda.BookKind, t time.Time, reason string) {
+	k := keyOf(instrument, kind, t)
+	if i, ok := m.failures[k]; ok {
+		m.Failures[i].Reason = reason
+		return
+	}
+	m.failures[k] = len(m.Failures)
+	m.Failures = append(m.Failures, Entry{instrument, kind, t.UTC(), reason})
+}
+
+// Resolve removes the snapshot from the failures.
+func (m *Manifest) Resolve(instrument oanda.Instrument, kind oanda.BookKind, t time.Time) {
+	k := keyOf(instrument, kind, t)
+	i, ok := m.failures[k]
+	if !ok {
+		return
+	}
+	m.Failures = append(m.Failures[:i], m.Failures[i+1:]...)
+	m.index()
+}
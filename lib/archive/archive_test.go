@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+func tempArchive(t *testing.T) *Archive {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return New(dir)
+}
+
+func TestArchive_Write(t *testing.T) {
+	a := tempArchive(t)
+	jst := time.FixedZone("JST", 9*60*60)
+	at := time.Date(2020, 10, 1, 9, 20, 0, 0, jst)
+	body := []byte(`{"orderBook":{"price":"105.512"}}`)
+
+	if a.Has(oanda.InstrumentUSDJPY, oanda.OrderBook, at) {
+		t.Errorf("Has() = true before Write()")
+	}
+	if err := a.Write(oanda.InstrumentUSDJPY, oanda.OrderBook, at, body); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if !a.Has(oanda.InstrumentUSDJPY, oanda.OrderBook, at) {
+		t.Errorf("Has() = false after Write()")
+	}
+	actual, err := a.Read(oanda.InstrumentUSDJPY, oanda.OrderBook, at.UTC())
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(actual) != string(body) {
+		t.Errorf("Read() = %s, expected: %s", actual, body)
+	}
+
+	// stored by the time in UTC
+	expected := filepath.Join(a.Dir(), "USD_JPY", "orderBook", "2020-10-01", "002000.json")
+	if path := a.Path(oanda.InstrumentUSDJPY, oanda.OrderBook, at); path != expected {
+		t.Errorf("Path() = %s, expected: %s", path, expected)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Write() left %d files, expected only the snapshot", len(files))
+	}
+
+	// another kind of the same time is stored apart
+	if a.Has(oanda.InstrumentUSDJPY, oanda.PositionBook, at) {
+		t.Errorf("Has() = true for the position book")
+	}
+}
+
+func TestArchive_Read_missing(t *testing.T) {
+	a := tempArchive(t)
+	_, err := a.Read(oanda.InstrumentUSDJPY, oanda.OrderBook, time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC))
+	if !os.IsNotExist(err) {
+		t.Errorf("Read() error = %v, expected a not exist error", err)
+	}
+}
+
+func TestArchive_LoadManifest_missing(t *testing.T) {
+	a := tempArchive(t)
+	m, err := a.LoadManifest()
+	if err != nil {
+		t.Fatalf("LoadManifest() error = %v", err)
+	}
+	if len(m.Gaps) != 0 || len(m.Failures) != 0 {
+		t.Errorf("LoadManifest() = %+v, expected an empty manifest", m)
+	}
+	if m.IsGap(oanda.InstrumentUSDJPY, oanda.OrderBook, time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("IsGap() = true in an empty manifest")
+	}
+}
+
+func TestArchive_LoadManifest_invalid(t *testing.T) {
+	a := tempArchive(t)
+	if err := ioutil.WriteFile(filepath.Join(a.Dir(), manifestName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.LoadManifest(); err == nil {
+		t.Errorf("LoadManifest() error = nil, expected an error of the broken manifest")
+	}
+}
+
+func TestManifest(t *testing.T) {
+	a := tempArchive(t)
+	since := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return since.Add(time.Duration(i) * 20 * time.Minute) }
+
+	m, err := a.LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddFailure(oanda.InstrumentUSDJPY, oanda.OrderBook, at(2), "500")
+	m.AddFailure(oanda.InstrumentUSDJPY, oanda.OrderBook, at(2), "503")
+	m.AddFailure(oanda.InstrumentUSDJPY, oanda.PositionBook, at(1), "timeout")
+	m.AddFailure(oanda.InstrumentEURUSD, oanda.OrderBook, at(0), "timeout")
+	m.AddGap(oanda.InstrumentUSDJPY, oanda.OrderBook, at(3), "not found")
+	// a failure which turns out to be a gap is not retried
+	m.AddGap(oanda.InstrumentUSDJPY, oanda.PositionBook, at(1), "not found")
+	m.Resolve(oanda.InstrumentEURUSD, oanda.OrderBook, at(0))
+	if err := a.SaveManifest(m); err != nil {
+		t.Fatalf("SaveManifest() error = %v", err)
+	}
+
+	loaded, err := a.LoadManifest()
+	if err != nil {
+		t.Fatalf("LoadManifest() error = %v", err)
+	}
+	expectedGaps := []Entry{
+		{Instrument: oanda.InstrumentUSDJPY, Kind: oanda.PositionBook, Time: at(1), Reason: "not found"},
+		{Instrument: oanda.InstrumentUSDJPY, Kind: oanda.OrderBook, Time: at(3), Reason: "not found"},
+	}
+	expectedFailures := []Entry{
+		{Instrument: oanda.InstrumentUSDJPY, Kind: oanda.OrderBook, Time: at(2), Reason: "503"},
+	}
+	if !reflect.DeepEqual(loaded.Gaps, expectedGaps) {
+		t.Errorf("Gaps = %+v, expected: %+v", loaded.Gaps, expectedGaps)
+	}
+	if !reflect.DeepEqual(loaded.Failures, expectedFailures) {
+		t.Errorf("Failures = %+v, expected: %+v", loaded.Failures, expectedFailures)
+	}
+	if loaded.UpdatedAt.IsZero() {
+		t.Errorf("UpdatedAt is not saved")
+	}
+
+	tests := []struct {
+		instrument oanda.Instrument
+		kind       oanda.BookKind
+		time       time.Time
+		expected   bool
+	}{
+		{instrument: oanda.InstrumentUSDJPY, kind: oanda.OrderBook, time: at(3), expected: true},
+		{instrument: oanda.InstrumentUSDJPY, kind: oanda.PositionBook, time: at(1), expected: true},
+		{instrument: oanda.InstrumentUSDJPY, kind: oanda.PositionBook, time: at(3), expected: false},
+		{instrument: oanda.InstrumentUSDJPY, kind: oanda.OrderBook, time: at(2), expected: false},
+		{instrument: oanda.InstrumentEURUSD, kind: oanda.OrderBook, time: at(3), expected: false},
+	}
+	for i, test := range tests {
+		if actual := loaded.IsGap(test.instrument, test.kind, test.time); actual != test.expected {
+			t.Errorf("#%d IsGap() = %v, expected: %v", i, actual, test.expected)
+		}
+	}
+
+	// the failures loaded are indexed so that they are resolved
+	loaded.Resolve(oanda.InstrumentUSDJPY, oanda.OrderBook, at(2))
+	if len(loaded.Failures) != 0 {
+		t.Errorf("Resolve() left %+v", loaded.Failures)
+	}
+}
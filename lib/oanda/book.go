@@ -1,6 +1,7 @@
 package oanda
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sort"
@@ -34,23 +35,55 @@ type Book struct {
 	Time       time.Time
 	Price      Price
 	Buckets    []BookBucket
+	// BucketWidth is the price range covered by a bucket from its price. It is the bucket width of
+	// the instrument if it is 0.
+	BucketWidth Price
+	// Precision is the precision of the prices of the book in Fixed. It is the display precision
+	// of the instrument if it is 0.
+	Precision int
+	// exactPrice and exactWidth are the price and the bucket width in Fixed as they are responded,
+	// which are set only by parsing the response if exact is true.
+	exact      bool
+	exactPrice Fixed
+	exactWidth Fixed
 }
 type BookBucket struct {
 	Price             Price   `json:"price"`
 	LongCountPercent  float64 `json:"longCountPercent"`
 	ShortCountPercent float64 `json:"shortCountPercent"`
+	// exactPrice is the price in Fixed as it is responded, which is set only by parsing the response
+	// if exact is true.
+	exact      bool
+	exactPrice Fixed
 }
 
 func (b *book) toBook() (*Book, error) {
-	price, err := strconv.ParseFloat(b.Price, 64)
+	// the prices are parsed in the precision which none of them exceeds
+	spec, _ := LookupInstrument(Instrument(b.Instrument))
+	precision := spec.DisplayPrecision
+	if d := decimalPlaces(b.Price); precision < d {
+		precision = d
+	}
+	for _, bu := range b.Buckets {
+		if d := decimalPlaces(bu.Price); precision < d {
+			precision = d
+		}
+	}
+	price, err := ParseFixed(b.Price, precision)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse price to float64: %v", err)
+		return nil, fmt.Errorf("failed to parse price: %v", err)
+	}
+	var width Fixed
+	if len(b.BucketWidth) > 0 {
+		if width, err = ParseFixed(b.BucketWidth, precision); err != nil {
+			return nil, fmt.Errorf("failed to parse bucket width: %v", err)
+		}
 	}
 	var buckets []BookBucket
 	for _, bu := range b.Buckets {
-		p, err := strconv.ParseFloat(bu.Price, 64)
+		p, err := ParseFixed(bu.Price, precision)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse bucket price to type of float64: %v", err)
+			return nil, fmt.Errorf("failed to parse bucket price: %v", err)
 		}
 		l, err := strconv.ParseFloat(bu.LongCountPercent, 64)
 		if err != nil {
@@ -61,57 +94,217 @@ func (b *book) toBook() (*Book, error) {
 			return nil, fmt.Errorf("failed to parse short count percent to float64: %v", err)
 		}
 		buckets = append(buckets, BookBucket{
-			Price(p),
-			l,
-			s,
+			Price:             p.Price(precision),
+			LongCountPercent:  l,
+			ShortCountPercent: s,
+			exact:             true,
+			exactPrice:        p,
 		})
 	}
 	return &Book{
-		Instrument(b.Instrument),
-		b.Time,
-		Price(price),
-		buckets,
+		Instrument:  Instrument(b.Instrument),
+		Time:        b.Time,
+		Price:       price.Price(precision),
+		Buckets:     buckets,
+		BucketWidth: width.Price(precision),
+		Precision:   precision,
+		exact:       true,
+		exactPrice:  price,
+		exactWidth:  width,
 	}, nil
 }
 
+// PricePrecision returns the precision of the prices of the book in Fixed.
+func (o *Book) PricePrecision() int {
+	if o.Precision > 0 {
+		return o.Precision
+	}
+	spec, _ := LookupInstrument(o.Instrument)
+	return spec.DisplayPrecision
+}
+
+// Fixed converts p to Fixed in the precision of the book.
+func (o *Book) Fixed(p Price) Fixed {
+	return ToFixed(p, o.PricePrecision())
+}
+
+// fixedPrice returns the price of the book in Fixed. The price responded is used if the book is
+// parsed, and Price is converted otherwise.
+func (o *Book) fixedPrice() Fixed {
+	if o.exact {
+		return o.exactPrice
+	}
+	return o.Fixed(o.Price)
+}
+
+// fixedLow returns the price of the bucket in Fixed, which is the low of its range.
+func (o *Book) fixedLow(b BookBucket) Fixed {
+	if b.exact {
+		return b.exactPrice
+	}
+	return o.Fixed(b.Price)
+}
+
+// fixedWidth returns Width() in Fixed. The bucket width of the instrument is used if the response
+// has none.
+func (o *Book) fixedWidth() Fixed {
+	if o.exact && o.exactWidth > 0 {
+		return o.exactWidth
+	}
+	return o.Fixed(o.Width())
+}
+
+// Width returns the price range covered by a bucket.
+func (o *Book) Width() Price {
+	if o.BucketWidth > 0 {
+		return o.BucketWidth
+	}
+	spec, _ := LookupInstrument(o.Instrument)
+	return spec.BucketWidth
+}
+
+// BucketFor returns the bucket whose range covers price. ok is false if no bucket covers it.
+func (o *Book) BucketFor(price Price) (bucket BookBucket, ok bool) {
+	p := o.Fixed(price)
+	for _, b := range o.Buckets {
+		if low, high := o.bucketRange(b); low <= p && p < high {
+			return b, true
+		}
+	}
+	return BookBucket{}, false
+}
+
+// BucketIndex looks up the buckets of a book by the prices they cover. The buckets are keyed by the
+// low of their ranges, which are on the grid of the bucket width as OANDA responds them.
+type BucketIndex struct {
+	buckets   map[Fixed]BookBucket
+	origin    Fixed
+	width     Fixed
+	precision int
+}
+
+// Index builds the index of the buckets of the book. It is built once and looked up for every
+// bucket of another book instead of scanning the buckets by BucketFor.
+func (o *Book) Index() *BucketIndex {
+	x := &BucketIndex{
+		buckets:   make(map[Fixed]BookBucket, len(o.Buckets)),
+		width:     o.fixedWidth(),
+		precision: o.PricePrecision(),
+	}
+	for i, b := range o.Buckets {
+		low := o.fixedLow(b)
+		if i == 0 {
+			x.origin = low
+		}
+		if _, ok := x.buckets[low]; !ok {
+			x.buckets[low] = b
+		}
+	}
+	return x
+}
+
+// Covering returns the bucket whose range covers the price of bucket b of book.
+// ok is false if no bucket covers it.
+func (x *BucketIndex) Covering(book *Book, b BookBucket) (bucket BookBucket, ok bool) {
+	if x.width <= 0 {
+		return BookBucket{}, false
+	}
+	p := book.fixedLow(b).Rescale(book.PricePrecision(), x.precision)
+	r := (p - x.origin) % x.width
+	if r < 0 {
+		r += x.width
+	}
+	bucket, ok = x.buckets[p-r]
+	return bucket, ok
+}
+
+// BucketRange returns the price range of i-th bucket of the book. It covers from low to just below high.
+func (o *Book) BucketRange(i int) (low, high Price) {
+	l, h := o.bucketRange(o.Buckets[i])
+	precision := o.PricePrecision()
+	return l.Price(precision), h.Price(precision)
+}
+
+func (o *Book) bucketRange(b BookBucket) (low, high Fixed) {
+	low = o.fixedLow(b)
+	return low, low + o.fixedWidth()
+}
+
+// DistancePips returns the distance from price to the range of the bucket in pips.
+// It is 0 if the bucket covers price.
+func (o *Book) DistancePips(bucket BookBucket, price Price) Pips {
+	return o.distancePips(bucket, o.Fixed(price))
+}
+
+// DistanceToPrice returns the distance from the price of the book to the range of the bucket in pips.
+func (o *Book) DistanceToPrice(bucket BookBucket) Pips {
+	return o.distancePips(bucket, o.fixedPrice())
+}
+
+func (o *Book) distancePips(bucket BookBucket, p Fixed) Pips {
+	low, high := o.bucketRange(bucket)
+	var d Fixed
+	if p < low {
+		d = low - p
+	} else if p >= high {
+		d = p - high
+	}
+	return d.Price(o.PricePrecision()).PriceToPips(o.Instrument)
+}
+
 func (o *Book) ExtractBucket(maxPrice, minPrice float64) {
+	max, min := o.Fixed(Price(maxPrice)), o.Fixed(Price(minPrice))
 	var buckets []BookBucket
 	for _, b := range o.Buckets {
-		if Price(maxPrice) >= b.Price && b.Price >= Price(minPrice) {
+		if p := o.fixedLow(b); max >= p && p >= min {
 			buckets = append(buckets, b)
 		}
 	}
 	o.Buckets = buckets
 }
 
+// ExtractBucketVicinityOfPrice returns n buckets below price from the highest and n buckets above price
+// from the lowest. It fails if the book has fewer buckets on either side.
 func (o *Book) ExtractBucketVicinityOfPrice(price Price, n int) (short, long []BookBucket, err error) {
-	sort.Slice(o.Buckets, func(i, j int) bool { return o.Buckets[i].Price < o.Buckets[j].Price })
-	var lowerBuckets []BookBucket
-	var higherBuckets []BookBucket
-	for i, b := range o.Buckets {
-		if b.Price > price {
-			lowerBuckets = o.Buckets[:i]
-			higherBuckets = o.Buckets[i:]
-			break
-		}
-	}
-	for i, j := 0, len(lowerBuckets)-1; i < j; i, j = i+1, j-1 {
-		lowerBuckets[i], lowerBuckets[j] = lowerBuckets[j], lowerBuckets[i]
-	}
-	if len(lowerBuckets[:n]) < n {
+	short, long = o.VicinityOfPrice(price, n)
+	if len(short) < n {
 		return nil, nil, fmt.Errorf("price is too low: lowerBuckets[%d] is not exist", n-1)
 	}
-	if len(higherBuckets[:n]) < n {
+	if len(long) < n {
 		return nil, nil, fmt.Errorf("price is too high: higherBuckets[%d] is not exist", n-1)
 	}
-	return lowerBuckets[:n], higherBuckets[:n], nil
+	return short, long, nil
 }
 
-func (c *Client) FetchOrderBook(instrument Instrument, dateTime *time.Time) (*Book, error) {
-	body, err := c.fetchOrderBook(instrument, dateTime)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch order book: %v", err)
+// VicinityOfPrice returns up to n buckets below price from the highest and up to n buckets above
+// price from the lowest. All of the buckets are returned if n is 0 or less. A side has fewer buckets
+// if the book is truncated near price. The buckets of the book are not modified.
+// The prices are compared in the precision of the book.
+func (o *Book) VicinityOfPrice(price Price, n int) (below, above []BookBucket) {
+	return o.vicinity(o.Fixed(price), n)
+}
+
+// Vicinity is like VicinityOfPrice around the price of the book.
+func (o *Book) Vicinity(n int) (below, above []BookBucket) {
+	return o.vicinity(o.fixedPrice(), n)
+}
+
+func (o *Book) vicinity(p Fixed, n int) (below, above []BookBucket) {
+	buckets := make([]BookBucket, len(o.Buckets))
+	copy(buckets, o.Buckets)
+	sort.Slice(buckets, func(i, j int) bool { return o.fixedLow(buckets[i]) < o.fixedLow(buckets[j]) })
+	i := sort.Search(len(buckets), func(i int) bool { return o.fixedLow(buckets[i]) > p })
+	for j := i - 1; j >= 0 && (n <= 0 || len(below) < n); j-- {
+		below = append(below, buckets[j])
+	}
+	for j := i; j < len(buckets) && (n <= 0 || len(above) < n); j++ {
+		above = append(above, buckets[j])
 	}
+	return below, above
+}
+
+// ParseOrderBook parses the response of the order book endpoint.
+func ParseOrderBook(body []byte) (*Book, error) {
 	var rb retrievedOrderBook
 	if err := json.Unmarshal(body, &rb); err != nil {
 		return nil, fmt.Errorf("failed to json unmarshal: %v", err)
@@ -123,22 +316,78 @@ func (c *Client) FetchOrderBook(instrument Instrument, dateTime *time.Time) (*Bo
 	return ob, nil
 }
 
-func (c *Client) FetchPositionBook(instrument Instrument, dateTime *time.Time) (*Book, error) {
-	body, err := c.fetchPositionBook(instrument, dateTime)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch position book: %v", err)
-	}
+// ParsePositionBook parses the response of the position book endpoint.
+func ParsePositionBook(body []byte) (*Book, error) {
 	var rb retrievedPositionBook
 	if err := json.Unmarshal(body, &rb); err != nil {
 		return nil, fmt.Errorf("failed to json unmarshal: %v", err)
 	}
-	ob, err := rb.Book.toBook()
+	pb, err := rb.Book.toBook()
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert book to position book: %v", err)
 	}
-	return ob, nil
+	return pb, nil
 }
 
+// ParseBook parses the response of the endpoint of kind.
+func ParseBook(kind BookKind, body []byte) (*Book, error) {
+	if kind == PositionBook {
+		return ParsePositionBook(body)
+	}
+	return ParseOrderBook(body)
+}
+
+// FetchOrderBook fetches the order book of instrument at dateTime.
+// The latest order book is fetched if dateTime is nil.
+func (c *Client) FetchOrderBook(instrument Instrument, dateTime *time.Time) (*Book, error) {
+	return c.FetchOrderBookContext(context.Background(), instrument, dateTime)
+}
+
+// FetchOrderBookContext is like FetchOrderBook but gives up when ctx is done.
+func (c *Client) FetchOrderBookContext(ctx context.Context, instrument Instrument, dateTime *time.Time) (*Book, error) {
+	body, err := c.fetchOrderBook(ctx, instrument, dateTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch order book: %w", err)
+	}
+	return ParseOrderBook(body)
+}
+
+// FetchPositionBook fetches the position book of instrument at dateTime.
+// The latest position book is fetched if dateTime is nil.
+func (c *Client) FetchPositionBook(instrument Instrument, dateTime *time.Time) (*Book, error) {
+	return c.FetchPositionBookContext(context.Background(), instrument, dateTime)
+}
+
+// FetchPositionBookContext is like FetchPositionBook but gives up when ctx is done.
+func (c *Client) FetchPositionBookContext(ctx context.Context, instrument Instrument, dateTime *time.Time) (*Book, error) {
+	body, err := c.fetchPositionBook(ctx, instrument, dateTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch position book: %w", err)
+	}
+	return ParsePositionBook(body)
+}
+
+// FetchOrderBookJSON fetches the order book of instrument at dateTime as it is responded.
 func (c *Client) FetchOrderBookJSON(instrument Instrument, dateTime *time.Time) ([]byte, error) {
-	return c.fetchOrderBook(instrument, dateTime)
+	return c.FetchOrderBookJSONContext(context.Background(), instrument, dateTime)
+}
+
+// FetchOrderBookJSONContext is like FetchOrderBookJSON but gives up when ctx is done.
+func (c *Client) FetchOrderBookJSONContext(ctx context.Context, instrument Instrument, dateTime *time.Time) ([]byte, error) {
+	return c.fetchOrderBook(ctx, instrument, dateTime)
+}
+
+// FetchPositionBookJSON fetches the position book of instrument at dateTime as it is responded.
+func (c *Client) FetchPositionBookJSON(instrument Instrument, dateTime *time.Time) ([]byte, error) {
+	return c.FetchPositionBookJSONContext(context.Background(), instrument, dateTime)
+}
+
+// FetchPositionBookJSONContext is like FetchPositionBookJSON but gives up when ctx is done.
+func (c *Client) FetchPositionBookJSONContext(ctx context.Context, instrument Instrument, dateTime *time.Time) ([]byte, error) {
+	return c.fetchPositionBook(ctx, instrument, dateTime)
+}
+
+// FetchBookJSONContext fetches the book of kind as it is responded.
+func (c *Client) FetchBookJSONContext(ctx context.Context, kind BookKind, instrument Instrument, dateTime *time.Time) ([]byte, error) {
+	return c.fetchBook(ctx, kind, instrument, dateTime)
 }
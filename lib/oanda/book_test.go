@@ -75,6 +75,32 @@ func TestBook_ExtractBucketVicinityOfPrice(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			fields: fields{
+				Instrument: InstrumentUSDJPY,
+				Buckets: []BookBucket{
+					{Price: 99.95},
+					{Price: 100.00},
+					{Price: 100.05},
+					{Price: 100.10},
+					{Price: 100.15},
+				},
+			},
+			args:    args{price: 100.001, n: 3},
+			wantErr: true,
+		},
+		{
+			fields: fields{
+				Instrument: InstrumentUSDJPY,
+				Buckets: []BookBucket{
+					{Price: 99.90},
+					{Price: 99.95},
+					{Price: 100.00},
+				},
+			},
+			args:    args{price: 100.001, n: 3},
+			wantErr: true,
+		},
 	}
 	for i, tt := range tests {
 		o := &Book{
@@ -84,7 +110,7 @@ func TestBook_ExtractBucketVicinityOfPrice(t *testing.T) {
 		gotShort, gotLong, err := o.ExtractBucketVicinityOfPrice(tt.args.price, tt.args.n)
 		if (err != nil) != tt.wantErr {
 			t.Errorf("#%d ExtractBucketVicinityOfPrice() error = %v, wantErr %v", i, err, tt.wantErr)
-			return
+			continue
 		}
 		if !reflect.DeepEqual(gotShort, tt.wantShort) {
 			t.Errorf("#%d ExtractBucketVicinityOfPrice() gotShort = %v, want %v", i, gotShort, tt.wantShort)
@@ -94,3 +120,153 @@ func TestBook_ExtractBucketVicinityOfPrice(t *testing.T) {
 		}
 	}
 }
+
+func TestBook_VicinityOfPrice(t *testing.T) {
+	buckets := []BookBucket{
+		{Price: 100.05},
+		{Price: 99.95},
+		{Price: 100.10},
+		{Price: 100.00},
+	}
+	tests := []struct {
+		price     Price
+		n         int
+		wantBelow []BookBucket
+		wantAbove []BookBucket
+	}{
+		{
+			price:     100.001,
+			n:         3,
+			wantBelow: []BookBucket{{Price: 100.00}, {Price: 99.95}},
+			wantAbove: []BookBucket{{Price: 100.05}, {Price: 100.10}},
+		},
+		{
+			price:     100.001,
+			n:         1,
+			wantBelow: []BookBucket{{Price: 100.00}},
+			wantAbove: []BookBucket{{Price: 100.05}},
+		},
+		{
+			price:     100.2,
+			n:         0,
+			wantBelow: []BookBucket{{Price: 100.10}, {Price: 100.05}, {Price: 100.00}, {Price: 99.95}},
+		},
+		{
+			price:     99.9,
+			n:         2,
+			wantAbove: []BookBucket{{Price: 99.95}, {Price: 100.00}},
+		},
+	}
+	for i, tt := range tests {
+		o := &Book{Instrument: InstrumentUSDJPY, Buckets: buckets}
+		gotBelow, gotAbove := o.VicinityOfPrice(tt.price, tt.n)
+		if !reflect.DeepEqual(gotBelow, tt.wantBelow) {
+			t.Errorf("#%d VicinityOfPrice() gotBelow = %v, want %v", i, gotBelow, tt.wantBelow)
+		}
+		if !reflect.DeepEqual(gotAbove, tt.wantAbove) {
+			t.Errorf("#%d VicinityOfPrice() gotAbove = %v, want %v", i, gotAbove, tt.wantAbove)
+		}
+		if o.Buckets[0].Price != 100.05 {
+			t.Errorf("#%d VicinityOfPrice() modified the buckets of the book", i)
+		}
+	}
+}
+
+func TestBook_geometry(t *testing.T) {
+	body := []byte(`{"orderBook":{"instrument":"EUR_USD","time":"2020-10-01T00:20:00Z","price":"1.18478","bucketWidth":"0.00050",` +
+		`"buckets":[{"price":"1.1840","longCountPercent":"0.1","shortCountPercent":"0.2"},{"price":"1.1845","longCountPercent":"0.3","shortCountPercent":"0"},` +
+		`{"price":"1.1850","longCountPercent":"0","shortCountPercent":"0.4"}]}}`)
+	o, err := ParseOrderBook(body)
+	if err != nil {
+		t.Fatalf("ParseOrderBook() error = %v", err)
+	}
+	if o.BucketWidth != 0.0005 || o.Width() != 0.0005 {
+		t.Errorf("BucketWidth = %v, Width() = %v, expected: 0.0005", o.BucketWidth, o.Width())
+	}
+
+	bucketForTests := []struct {
+		price    Price
+		expected Price
+		ok       bool
+	}{
+		{1.18478, 1.1845, true},
+		{1.1845, 1.1845, true},
+		{1.18499, 1.1845, true},
+		{1.1850, 1.1850, true},
+		{1.18549, 1.1850, true},
+		{1.1855, 0, false},
+		{1.18399, 0, false},
+	}
+	for i, tt := range bucketForTests {
+		b, ok := o.BucketFor(tt.price)
+		if b.Price != tt.expected || ok != tt.ok {
+			t.Errorf("#%d BucketFor(%v) = %v, %v, expected: %v, %v", i, tt.price, b.Price, ok, tt.expected, tt.ok)
+		}
+	}
+
+	if low, high := o.BucketRange(1); low != 1.1845 || high != 1.185 {
+		t.Errorf("BucketRange(1) = %v, %v, expected: 1.1845, 1.185", low, high)
+	}
+
+	distanceTests := []struct {
+		bucket   int
+		price    Price
+		expected Pips
+	}{
+		{0, 1.18478, 2.8},
+		{1, 1.18478, 0},
+		{2, 1.18478, 2.2},
+		{2, 1.1845, 5},
+		{0, 1.1845, 0},
+	}
+	for i, tt := range distanceTests {
+		if actual := o.DistancePips(o.Buckets[tt.bucket], tt.price); actual != tt.expected {
+			t.Errorf("#%d DistancePips() = %v, expected: %v", i, actual, tt.expected)
+		}
+	}
+
+	// the bucket width of the instrument is used if the book has none
+	o = &Book{Instrument: InstrumentUSDJPY, Buckets: []BookBucket{{Price: 105.40}, {Price: 105.45}}}
+	if actual := o.Width(); actual != 0.05 {
+		t.Errorf("Width() = %v, expected: 0.05", actual)
+	}
+	if actual := o.DistancePips(o.Buckets[0], 105.512); actual != 6.2 {
+		t.Errorf("DistancePips() = %v, expected: 6.2", actual)
+	}
+}
+
+func TestBucketIndex_Covering(t *testing.T) {
+	positionBook, err := ParsePositionBook([]byte(`{"positionBook":{"instrument":"USD_JPY","time":"2020-10-01T00:20:00Z","price":"105.512","bucketWidth":"0.100",` +
+		`"buckets":[{"price":"105.300","longCountPercent":"0.1","shortCountPercent":"0.2"},{"price":"105.400","longCountPercent":"0.3","shortCountPercent":"0.4"},` +
+		`{"price":"105.500","longCountPercent":"0.5","shortCountPercent":"0.6"},{"price":"105.700","longCountPercent":"0.7","shortCountPercent":"0.8"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the order book is finer in both the width and the precision
+	orderBook := &Book{Instrument: InstrumentUSDJPY, Precision: 4, BucketWidth: 0.05}
+	for p := 105.2; p < 105.9; p += 0.0125 {
+		orderBook.Buckets = append(orderBook.Buckets, BookBucket{Price: Price(p)})
+	}
+
+	x := positionBook.Index()
+	covered := 0
+	for i, b := range orderBook.Buckets {
+		actual, ok := x.Covering(orderBook, b)
+		expected, expectedOK := positionBook.BucketFor(b.Price)
+		if !reflect.DeepEqual(actual, expected) || ok != expectedOK {
+			t.Errorf("#%d Covering(%v) = %v, %v, expected: %v, %v", i, b.Price, actual, ok, expected, expectedOK)
+		}
+		if ok {
+			covered++
+		}
+	}
+	// 105.3 to just below 105.6 and 105.7 to just below 105.8 are covered
+	if covered != 32 {
+		t.Errorf("Covering() covered %d buckets, expected: 32", covered)
+	}
+
+	// a book without a bucket width covers nothing
+	if _, ok := (&Book{Instrument: "XXX_YYY", Buckets: []BookBucket{{Price: 1}}}).Index().Covering(orderBook, orderBook.Buckets[0]); ok {
+		t.Errorf("Covering() ok = true without a bucket width")
+	}
+}
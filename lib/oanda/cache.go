@@ -0,0 +1,150 @@
+package oanda
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib"
+)
+
+// BookKind distinguishes order books from position books.
+type BookKind string
+
+const (
+	OrderBook    = BookKind("orderBook")
+	PositionBook = BookKind("positionBook")
+)
+
+// cacheMinAge is the age from which snapshots are regarded as immutable.
+// Requests for later times may be answered with the latest snapshot which is still changing.
+const cacheMinAge = time.Hour
+
+// instrumentsMaxAge is the age until which the cached instruments of an account are used.
+const instrumentsMaxAge = 24 * time.Hour
+
+// Cache stores books responded by OANDA API as gzip compressed JSON files under a directory.
+// A client stores the responses under the subdirectory named after the host of its endpoint,
+// so that practice, trade and fake servers never share them.
+//
+// Books are keyed by instrument, kind and the requested time in UTC truncated to seconds, not by
+// the time of the snapshot responded. Requests for different times answered with the same
+// snapshot are cached separately. The instruments of accounts are stored under accounts.
+type Cache struct {
+	dir string
+}
+
+// NewCache constructs Cache storing files under dir.
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// forEndpoint returns the cache of the responses of the API at endpoint.
+func (c *Cache) forEndpoint(endpoint string) *Cache {
+	host := endpoint
+	if u, err := url.Parse(endpoint); err == nil && len(u.Host) > 0 {
+		host = u.Host
+	}
+	// ports are separated by _ since : is not allowed in file names on every platform
+	host = strings.NewReplacer(":", "_", "/", "_").Replace(host)
+	return &Cache{dir: filepath.Join(c.dir, host)}
+}
+
+// cacheable reports whether the book requested at dateTime never changes.
+func cacheable(dateTime *time.Time, now time.Time) bool {
+	return dateTime != nil && Settled(*dateTime, now)
+}
+
+// Settled reports whether the snapshot at t is old enough at now that OANDA no longer changes it.
+// A snapshot which is not settled may not be published yet.
+func Settled(t, now time.Time) bool {
+	return now.Sub(t) >= cacheMinAge
+}
+
+func (c *Cache) path(instrument Instrument, kind BookKind, dateTime time.Time) string {
+	name := dateTime.UTC().Format("20060102T150405Z") + ".json.gz"
+	return filepath.Join(c.dir, string(instrument), string(kind), name)
+}
+
+// Load returns the cached book. ok is false if the book is not cached.
+func (c *Cache) Load(instrument Instrument, kind BookKind, dateTime time.Time) (body []byte, ok bool, err error) {
+	return c.read(c.path(instrument, kind, dateTime))
+}
+
+// Store caches the book.
+func (c *Cache) Store(instrument Instrument, kind BookKind, dateTime time.Time, body []byte) error {
+	return c.write(c.path(instrument, kind, dateTime), body)
+}
+
+func (c *Cache) instrumentsPath(accountID string) string {
+	return filepath.Join(c.dir, "accounts", accountID, "instruments.json.gz")
+}
+
+// LoadInstruments returns the cached instruments of the account. ok is false if they are not
+// cached or cached instrumentsMaxAge ago.
+func (c *Cache) LoadInstruments(accountID string) (body []byte, ok bool, err error) {
+	path := c.instrumentsPath(accountID)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open cache: %v", err)
+	}
+	if time.Since(info.ModTime()) >= instrumentsMaxAge {
+		return nil, false, nil
+	}
+	return c.read(path)
+}
+
+// StoreInstruments caches the instruments of the account.
+func (c *Cache) StoreInstruments(accountID string, body []byte) error {
+	return c.write(c.instrumentsPath(accountID), body)
+}
+
+// read reads the gzip compressed file at path. ok is false if it does not exist.
+func (c *Cache) read(path string) (body []byte, ok bool, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open cache: %v", err)
+	}
+	defer lib.SafeClose(f)
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache: %v", err)
+	}
+	defer lib.SafeClose(r)
+	body, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache: %v", err)
+	}
+	return body, true, nil
+}
+
+// write compresses body into the file at path by lib.WriteFileAtomic.
+func (c *Cache) write(path string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("failed to compress cache: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to compress cache: %v", err)
+	}
+	if err := lib.WriteFileAtomic(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write cache file: %v", err)
+	}
+	return nil
+}
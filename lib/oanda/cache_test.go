@@ -0,0 +1,118 @@
+package oanda
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda/oandatest"
+)
+
+func TestClient_FetchOrderBook_cache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oanda-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	past := time.Date(2020, 10, 1, 0, 20, 0, 0, time.UTC)
+	recent := time.Now().UTC().Truncate(oandatest.Granularity)
+	srv := oandatest.NewServer()
+	defer srv.Close()
+	srv.AddOrderBook(oandatest.NewBook("USD_JPY", past, "105.512", "0.05", 3))
+	srv.AddOrderBook(oandatest.NewBook("USD_JPY", recent, "105.512", "0.05", 3))
+	srv.AddPositionBook(oandatest.NewBook("USD_JPY", past, "105.512", "0.05", 3))
+
+	c := NewClient("key", WithBaseURL(srv.URL), WithCache(NewCache(dir)))
+	for i := 0; i < 2; i++ {
+		if _, err := c.FetchOrderBook(InstrumentUSDJPY, &past); err != nil {
+			t.Fatalf("FetchOrderBook() error = %v", err)
+		}
+		if _, err := c.FetchPositionBook(InstrumentUSDJPY, &past); err != nil {
+			t.Fatalf("FetchPositionBook() error = %v", err)
+		}
+		if _, err := c.FetchOrderBook(InstrumentUSDJPY, &recent); err != nil {
+			t.Fatalf("FetchOrderBook() error = %v", err)
+		}
+	}
+	// past books are fetched once, the recent book every time
+	if n := len(srv.Requests()); n != 4 {
+		t.Errorf("Requests() = %d, want 4", n)
+	}
+	host := strings.Replace(strings.TrimPrefix(srv.URL, "http://"), ":", "_", 1)
+	if _, err := os.Stat(filepath.Join(dir, host, "USD_JPY", "orderBook", "20201001T002000Z.json.gz")); err != nil {
+		t.Errorf("order book is not cached: %v", err)
+	}
+
+	// another client shares the cache
+	c = NewClient("key", WithBaseURL(srv.URL), WithCache(NewCache(dir)))
+	book, err := c.FetchOrderBook(InstrumentUSDJPY, &past)
+	if err != nil {
+		t.Fatalf("FetchOrderBook() error = %v", err)
+	}
+	if !book.Time.Equal(past) || len(book.Buckets) != 6 {
+		t.Errorf("FetchOrderBook() = %+v", book)
+	}
+	if n := len(srv.Requests()); n != 4 {
+		t.Errorf("Requests() = %d, want 4", n)
+	}
+}
+
+func TestClient_FetchOrderBook_cachePerEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oanda-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	past := time.Date(2020, 10, 1, 0, 20, 0, 0, time.UTC)
+	var servers []*oandatest.Server
+	for _, price := range []string{"105.512", "106.512"} {
+		srv := oandatest.NewServer()
+		defer srv.Close()
+		srv.AddOrderBook(oandatest.NewBook("USD_JPY", past, price, "0.05", 3))
+		servers = append(servers, srv)
+	}
+	cache := NewCache(dir)
+	for i, expected := range []Price{105.512, 106.512} {
+		c := NewClient("key", WithBaseURL(servers[i].URL), WithCache(cache))
+		book, err := c.FetchOrderBook(InstrumentUSDJPY, &past)
+		if err != nil {
+			t.Fatalf("#%d FetchOrderBook() error = %v", i, err)
+		}
+		if book.Price != expected {
+			t.Errorf("#%d FetchOrderBook() price = %v, expected: %v", i, book.Price, expected)
+		}
+		if n := len(servers[i].Requests()); n != 1 {
+			t.Errorf("#%d Requests() = %d, want 1", i, n)
+		}
+	}
+}
+
+func TestCache_forEndpoint(t *testing.T) {
+	c := NewCache("cache")
+	tests := []struct {
+		endpoint string
+		expected string
+	}{
+		{endpoint: EnvironmentPractice.BaseURL(), expected: filepath.Join("cache", "api-fxpractice.oanda.com")},
+		{endpoint: EnvironmentTrade.BaseURL(), expected: filepath.Join("cache", "api-fxtrade.oanda.com")},
+		{endpoint: "http://127.0.0.1:8080", expected: filepath.Join("cache", "127.0.0.1_8080")},
+	}
+	for i, test := range tests {
+		if actual := c.forEndpoint(test.endpoint).dir; actual != test.expected {
+			t.Errorf("#%d forEndpoint() = %v, expected: %v", i, actual, test.expected)
+		}
+	}
+}
+
+func TestCache_Load_missing(t *testing.T) {
+	c := NewCache(filepath.Join(os.TempDir(), "oanda-cache-missing"))
+	_, ok, err := c.Load(InstrumentUSDJPY, OrderBook, time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC))
+	if ok || err != nil {
+		t.Errorf("Load() = %v, %v, want not ok without error", ok, err)
+	}
+}
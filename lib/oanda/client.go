@@ -1,8 +1,10 @@
 package oanda
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"time"
 
@@ -11,87 +13,140 @@ import (
 
 const authorizationPrefix = "Bearer "
 
+// DefaultTimeout is the time limit of a request sent by clients built with NewClient.
+const DefaultTimeout = 30 * time.Second
+
 // Client implements operations trade of oanda through OANDA API.
 type Client struct {
 	client          *http.Client
 	endpoint        string
 	requiredHeaders http.Header
+	retryPolicy     RetryPolicy
+	limiter         *rateLimiter
+	cache           *Cache
 }
 
 // NewClient constructs OANDA API client objects.
-func NewClient(apiKey string, environment string) Client {
+func NewClient(apiKey string, opts ...Option) *Client {
+	o := buildOptions(opts)
 	requiredHeaders := http.Header{}
 	requiredHeaders.Add("Authorization", authorizationPrefix+apiKey)
 	requiredHeaders.Add("Content-Type", "application/json")
-	var endpoint string
-	if environment == "Trade" {
-		endpoint = "https://api-fxtrade.oanda.com"
-	} else {
-		endpoint = "https://api-fxpractice.oanda.com"
+	requiredHeaders.Add("Accept-Datetime-Format", "RFC3339")
+	if len(o.userAgent) > 0 {
+		requiredHeaders.Add("User-Agent", o.userAgent)
+	}
+	endpoint := o.endpoint()
+	var cache *Cache
+	if o.cache != nil {
+		cache = o.cache.forEndpoint(endpoint)
 	}
-	return Client{
-		client:          &http.Client{},
+	return &Client{
+		client:          o.client(),
 		endpoint:        endpoint,
 		requiredHeaders: requiredHeaders,
+		retryPolicy:     o.retryPolicy,
+		limiter:         newRateLimiter(o.rateLimit, o.burst),
+		cache:           cache,
 	}
 }
 
-func (c *Client) fetchOrderBook(instrument Instrument, dateTime *time.Time) ([]byte, error) {
-	url := c.endpoint + "/v3/instruments/" + string(instrument) + "/orderBook"
-	if dateTime != nil {
-		url = c.endpoint + "/v3/instruments/" + string(instrument) + "/orderBook?time=" + dateTime.UTC().Format(time.RFC3339Nano)
-	}
-	req, err := http.NewRequest(
-		http.MethodGet,
-		url,
-		nil,
-	)
+func (c *Client) fetchOrderBook(ctx context.Context, instrument Instrument, dateTime *time.Time) ([]byte, error) {
+	return c.fetchBook(ctx, OrderBook, instrument, dateTime)
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to build request: %v", err)
+func (c *Client) fetchPositionBook(ctx context.Context, instrument Instrument, dateTime *time.Time) ([]byte, error) {
+	return c.fetchBook(ctx, PositionBook, instrument, dateTime)
+}
+
+// fetchBook fetches the book through the cache if the client has one.
+func (c *Client) fetchBook(ctx context.Context, kind BookKind, instrument Instrument, dateTime *time.Time) ([]byte, error) {
+	useCache := c.cache != nil && cacheable(dateTime, time.Now())
+	if useCache {
+		body, ok, err := c.cache.Load(instrument, kind, *dateTime)
+		if err != nil {
+			log.Printf("failed to load %s from cache: %v", kind, err)
+		}
+		if ok {
+			return body, nil
+		}
 	}
-	c.requiredHeaders.Add("Accept-Datetime-Format", "RFC3339")
-	req.Header = c.requiredHeaders
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch response: %v", err)
+	url := c.endpoint + "/v3/instruments/" + string(instrument) + "/" + string(kind)
+	if dateTime != nil {
+		url = c.endpoint + "/v3/instruments/" + string(instrument) + "/" + string(kind) + "?time=" + dateTime.UTC().Format(time.RFC3339Nano)
 	}
-	defer lib.SafeClose(resp.Body)
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := c.get(ctx, url)
 	if err != nil {
-		return nil, fmt.Errorf("HTTP %s: failed to read response body: %v", resp.Status, err)
+		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %s: %s", resp.Status, body)
+	if useCache {
+		if err := c.cache.Store(instrument, kind, *dateTime, body); err != nil {
+			log.Printf("failed to store %s in cache: %v", kind, err)
+		}
 	}
 	return body, nil
 }
-func (c *Client) fetchPositionBook(instrument Instrument, dateTime *time.Time) ([]byte, error) {
-	url := c.endpoint + "/v3/instruments/" + string(instrument) + "/positionBook"
-	if dateTime != nil {
-		url = c.endpoint + "/v3/instruments/" + string(instrument) + "/positionBook?time=" + dateTime.UTC().Format(time.RFC3339Nano)
+
+// get sends GET request to url and retries it according to the retry policy.
+// It gives up as soon as ctx is done and returns the error of ctx.
+func (c *Client) get(ctx context.Context, url string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+		body, err := c.do(ctx, url)
+		if err == nil {
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !err.Retriable() || attempt >= c.retryPolicy.MaxRetries {
+			return nil, err
+		}
+		if err := sleep(ctx, c.retryPolicy.delay(attempt, err)); err != nil {
+			return nil, err
+		}
 	}
-	req, err := http.NewRequest(
+}
+
+func (c *Client) do(ctx context.Context, url string) ([]byte, *Error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodGet,
 		url,
 		nil,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build request: %v", err)
+		return nil, &Error{Kind: ErrorKindClient, Err: fmt.Errorf("failed to build request: %v", err)}
 	}
-	c.requiredHeaders.Add("Accept-Datetime-Format", "RFC3339")
-	req.Header = c.requiredHeaders
+	req.Header = c.requiredHeaders.Clone()
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch response: %v", err)
+		return nil, &Error{Kind: ErrorKindNetwork, Err: fmt.Errorf("failed to fetch response: %v", err)}
 	}
 	defer lib.SafeClose(resp.Body)
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("HTTP %s: failed to read response body: %v", resp.Status, err)
+		return nil, &Error{Kind: ErrorKindNetwork, StatusCode: resp.StatusCode, Status: resp.Status, Err: fmt.Errorf("failed to read response body: %v", err)}
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %s: %s", resp.Status, body)
+		return nil, newStatusError(resp, body)
 	}
 	return body, nil
-}
\ No newline at end of file
+}
+
+// sleep pauses for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,259 @@
+package oanda
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_get(t *testing.T) {
+	tests := []struct {
+		statuses     []int
+		retryAfter   string
+		wantErr      bool
+		wantKind     ErrorKind
+		wantAttempts int32
+	}{
+		{statuses: []int{http.StatusOK}, wantAttempts: 1},
+		{statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, wantAttempts: 3},
+		{statuses: []int{http.StatusTooManyRequests, http.StatusOK}, retryAfter: "0", wantAttempts: 2},
+		{statuses: []int{http.StatusBadRequest, http.StatusOK}, wantErr: true, wantKind: ErrorKindClient, wantAttempts: 1},
+		{statuses: []int{http.StatusNotFound}, wantErr: true, wantKind: ErrorKindClient, wantAttempts: 1},
+		{
+			statuses:     []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			wantErr:      true,
+			wantKind:     ErrorKindServer,
+			wantAttempts: 3,
+		},
+		{
+			statuses:     []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests},
+			wantErr:      true,
+			wantKind:     ErrorKindRateLimit,
+			wantAttempts: 3,
+		},
+	}
+	for i, tt := range tests {
+		var attempts int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&attempts, 1)
+			status := tt.statuses[len(tt.statuses)-1]
+			if int(n) <= len(tt.statuses) {
+				status = tt.statuses[n-1]
+			}
+			if len(tt.retryAfter) > 0 {
+				w.Header().Set("Retry-After", tt.retryAfter)
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("{}"))
+		}))
+		c := NewClient("key",
+			WithBaseURL(srv.URL),
+			WithRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}),
+		)
+		_, err := c.get(context.Background(), srv.URL+"/v3/instruments/USD_JPY/orderBook")
+		srv.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("#%d get() error = %v, wantErr %v", i, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			if e, ok := err.(*Error); !ok || e.Kind != tt.wantKind {
+				t.Errorf("#%d get() error = %#v, want kind %v", i, err, tt.wantKind)
+			}
+		}
+		if attempts != tt.wantAttempts {
+			t.Errorf("#%d get() attempts = %d, want %d", i, attempts, tt.wantAttempts)
+		}
+	}
+}
+
+func TestClient_get_networkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+	c := NewClient("key", WithRetryPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond}))
+	_, err := c.get(context.Background(), url)
+	if e, ok := err.(*Error); !ok || e.Kind != ErrorKindNetwork {
+		t.Errorf("get() error = %#v, want kind %v", err, ErrorKindNetwork)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{input: "", expected: 0},
+		{input: "3", expected: 3 * time.Second},
+		{input: "-1", expected: 0},
+		{input: "Thu, 01 Oct 2020 00:00:10 GMT", expected: 10 * time.Second},
+		{input: "Wed, 30 Sep 2020 23:59:50 GMT", expected: 0},
+		{input: "soon", expected: 0},
+	}
+	for i, test := range tests {
+		if actual := parseRetryAfter(test.input, now); actual != test.expected {
+			t.Errorf("#%d parseRetryAfter() = %v, expected: %v", i, actual, test.expected)
+		}
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 0, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 1, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{attempt: 3, min: 400 * time.Millisecond, max: 800 * time.Millisecond},
+		{attempt: 8, min: 500 * time.Millisecond, max: time.Second},
+	}
+	for i, test := range tests {
+		for n := 0; n < 20; n++ {
+			if actual := p.backoff(test.attempt); actual < test.min || actual > test.max {
+				t.Errorf("#%d backoff() = %v, expected: [%v, %v]", i, actual, test.min, test.max)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy_backoff_unlimited(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 10, BaseDelay: 100 * time.Millisecond}
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 0, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 3, min: 400 * time.Millisecond, max: 800 * time.Millisecond},
+		{attempt: 8, min: 12800 * time.Millisecond, max: 25600 * time.Millisecond},
+		{attempt: 100, min: math.MaxInt64 / 4, max: math.MaxInt64},
+	}
+	for i, test := range tests {
+		if actual := p.backoff(test.attempt); actual < test.min || actual > test.max {
+			t.Errorf("#%d backoff() = %v, expected: [%v, %v]", i, actual, test.min, test.max)
+		}
+	}
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	tests := []struct {
+		policy     RetryPolicy
+		retryAfter time.Duration
+		expected   time.Duration
+	}{
+		{policy: RetryPolicy{MaxDelay: 30 * time.Second}, retryAfter: 3 * time.Second, expected: 3 * time.Second},
+		{policy: RetryPolicy{MaxDelay: 30 * time.Second}, retryAfter: time.Hour, expected: 30 * time.Second},
+		{policy: RetryPolicy{}, retryAfter: 3 * time.Second, expected: 3 * time.Second},
+		{policy: RetryPolicy{}, retryAfter: 24 * time.Hour, expected: maxRetryAfter},
+	}
+	for i, test := range tests {
+		if actual := test.policy.delay(0, &Error{RetryAfter: test.retryAfter}); actual != test.expected {
+			t.Errorf("#%d delay() = %v, expected: %v", i, actual, test.expected)
+		}
+	}
+}
+
+func TestClient_get_canceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	c := NewClient("key")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.get(ctx, srv.URL)
+	if err != context.DeadlineExceeded {
+		t.Errorf("get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("get() returned after %v, want it to give up when ctx is done", elapsed)
+	}
+}
+
+func TestClient_get_timeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+	c := NewClient("key", WithTimeout(20*time.Millisecond), WithRetryPolicy(NoRetry))
+	_, err := c.get(context.Background(), srv.URL)
+	if e, ok := err.(*Error); !ok || e.Kind != ErrorKindNetwork {
+		t.Errorf("get() error = %#v, want kind %v", err, ErrorKindNetwork)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		opts          []Option
+		wantEndpoint  string
+		wantUserAgent string
+	}{
+		{
+			opts:          nil,
+			wantEndpoint:  "https://api-fxpractice.oanda.com",
+			wantUserAgent: DefaultUserAgent,
+		},
+		{
+			opts:          []Option{WithEnvironment(EnvironmentTrade), WithUserAgent("test-agent")},
+			wantEndpoint:  "https://api-fxtrade.oanda.com",
+			wantUserAgent: "test-agent",
+		},
+		{
+			opts:          []Option{WithEnvironment(EnvironmentTrade), WithBaseURL("http://127.0.0.1:8080/")},
+			wantEndpoint:  "http://127.0.0.1:8080",
+			wantUserAgent: DefaultUserAgent,
+		},
+	}
+	for i, tt := range tests {
+		c := NewClient("key", tt.opts...)
+		if c.endpoint != tt.wantEndpoint {
+			t.Errorf("#%d NewClient() endpoint = %s, want %s", i, c.endpoint, tt.wantEndpoint)
+		}
+		if ua := c.requiredHeaders.Get("User-Agent"); ua != tt.wantUserAgent {
+			t.Errorf("#%d NewClient() User-Agent = %s, want %s", i, ua, tt.wantUserAgent)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestNewClient_transport(t *testing.T) {
+	var got *http.Request
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		got = r
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       ioutil.NopCloser(strings.NewReader("{}")),
+		}, nil
+	})
+	hc := &http.Client{Timeout: time.Minute}
+	c := NewClient("key", WithHTTPClient(hc), WithTransport(transport), WithBaseURL("http://oanda.test"))
+	if _, err := c.get(context.Background(), c.endpoint+"/v3/instruments/USD_JPY/orderBook"); err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if got == nil || got.URL.Host != "oanda.test" {
+		t.Fatalf("get() did not go through the transport: %v", got)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Bearer key" {
+		t.Errorf("get() Authorization = %s, want Bearer key", auth)
+	}
+	if hc.Transport != nil {
+		t.Errorf("NewClient() modified the given http.Client")
+	}
+}
@@ -0,0 +1,99 @@
+package oanda
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorKind classifies failures of OANDA API requests.
+type ErrorKind int
+
+const (
+	// ErrorKindNetwork means the request did not get a response.
+	ErrorKindNetwork ErrorKind = iota
+	// ErrorKindServer means OANDA responded with a 5xx status.
+	ErrorKindServer
+	// ErrorKindRateLimit means OANDA responded with 429 Too Many Requests.
+	ErrorKindRateLimit
+	// ErrorKindClient means OANDA responded with a 4xx status other than 429.
+	ErrorKindClient
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindNetwork:
+		return "network"
+	case ErrorKindServer:
+		return "server"
+	case ErrorKindRateLimit:
+		return "rate limit"
+	case ErrorKindClient:
+		return "client"
+	}
+	return "unknown"
+}
+
+// Error is returned when a request to OANDA API fails.
+type Error struct {
+	Kind       ErrorKind
+	StatusCode int
+	Status     string
+	Body       []byte
+	RetryAfter time.Duration // zero if OANDA did not send Retry-After
+	Err        error         // cause of failures other than error statuses
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		return e.Err.Error()
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("HTTP %s: %v", e.Status, e.Err)
+	}
+	return fmt.Sprintf("HTTP %s: %s", e.Status, e.Body)
+}
+
+// Retriable reports whether the failed request is worth sending again.
+func (e *Error) Retriable() bool {
+	return e.Kind != ErrorKindClient
+}
+
+func newStatusError(resp *http.Response, body []byte) *Error {
+	kind := ErrorKindClient
+	if resp.StatusCode == http.StatusTooManyRequests {
+		kind = ErrorKindRateLimit
+	} else if resp.StatusCode >= 500 {
+		kind = ErrorKindServer
+	}
+	return &Error{
+		Kind:       kind,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       body,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter parses the value of Retry-After header which is either
+// delay seconds or HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if len(v) == 0 {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0
+		}
+		return time.Duration(s) * time.Second
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0
+	}
+	if d := t.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
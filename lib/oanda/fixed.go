@@ -0,0 +1,89 @@
+package oanda
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Fixed is a price in fixed point, which is the price multiplied by 10^precision. The precision is
+// given by the book or the instrument of the price. Decimal prices responded by OANDA API are exact
+// in Fixed, so that prices are compared and used as keys without the rounding errors of float64.
+type Fixed int64
+
+// ParseFixed parses the decimal price s such as 105.512 into Fixed of precision.
+// It fails if s has more decimal places than precision.
+func ParseFixed(s string, precision int) (Fixed, error) {
+	str := s
+	negative := strings.HasPrefix(str, "-")
+	if negative {
+		str = str[1:]
+	}
+	integer, fraction := str, ""
+	if i := strings.Index(str, "."); i >= 0 {
+		integer, fraction = str[:i], str[i+1:]
+	}
+	if len(fraction) > precision {
+		return 0, fmt.Errorf("invalid price: %s has more than %d decimal places", s, precision)
+	}
+	if len(integer) == 0 || strings.ContainsAny(integer+fraction, "+-") {
+		return 0, fmt.Errorf("invalid price: %s", s)
+	}
+	v, err := strconv.ParseInt(integer+fraction+strings.Repeat("0", precision-len(fraction)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid price: %s: %v", s, err)
+	}
+	if negative {
+		v = -v
+	}
+	return Fixed(v), nil
+}
+
+// decimalPlaces returns the number of decimal places of the decimal price s.
+func decimalPlaces(s string) int {
+	if i := strings.Index(s, "."); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+// ToFixed rounds p to Fixed of precision.
+func ToFixed(p Price, precision int) Fixed {
+	return Fixed(math.Round(float64(p) * math.Pow10(precision)))
+}
+
+// Price converts f of precision to Price, which is the nearest float64 to the decimal price.
+func (f Fixed) Price(precision int) Price {
+	return Price(float64(f) / math.Pow10(precision))
+}
+
+// Rescale converts f of precision from to Fixed of precision to. It is rounded down if to is coarser.
+func (f Fixed) Rescale(from, to int) Fixed {
+	if to >= from {
+		return f * Fixed(math.Pow10(to-from))
+	}
+	d := Fixed(math.Pow10(from - to))
+	q := f / d
+	if f%d != 0 && f < 0 {
+		q--
+	}
+	return q
+}
+
+// Format formats f of precision with precision decimal places.
+func (f Fixed) Format(precision int) string {
+	sign := ""
+	v := int64(f)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	digits := strconv.FormatInt(v, 10)
+	if precision <= 0 {
+		return sign + digits + strings.Repeat("0", -precision)
+	}
+	if len(digits) <= precision {
+		digits = strings.Repeat("0", precision-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-precision] + "." + digits[len(digits)-precision:]
+}
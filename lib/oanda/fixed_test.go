@@ -0,0 +1,169 @@
+package oanda
+
+import "testing"
+
+func TestParseFixed(t *testing.T) {
+	tests := []struct {
+		s         string
+		precision int
+		expected  Fixed
+		isErr     bool
+	}{
+		{"105.512", 3, 105512, false},
+		{"105.40", 3, 105400, false},
+		{"105", 3, 105000, false},
+		{"1.18478", 5, 118478, false},
+		{"1.1860", 5, 118600, false},
+		{"0.00050", 5, 50, false},
+		{"-0.05", 3, -50, false},
+		{"23510.0", 1, 235100, false},
+		{"1.184785", 5, 0, true},
+		{"", 3, 0, true},
+		{".5", 3, 0, true},
+		{"1.-5", 3, 0, true},
+		{"1e5", 3, 0, true},
+		{"abc", 3, 0, true},
+	}
+	for i, tt := range tests {
+		actual, err := ParseFixed(tt.s, tt.precision)
+		if (err != nil) != tt.isErr {
+			t.Errorf("#%d ParseFixed(%q) error = %v", i, tt.s, err)
+			continue
+		}
+		if actual != tt.expected {
+			t.Errorf("#%d ParseFixed(%q) = %v, expected: %v", i, tt.s, actual, tt.expected)
+		}
+	}
+}
+
+func TestFixed_Format(t *testing.T) {
+	tests := []struct {
+		f         Fixed
+		precision int
+		expected  string
+	}{
+		{105512, 3, "105.512"},
+		{118600, 5, "1.18600"},
+		{50, 5, "0.00050"},
+		{-50, 3, "-0.050"},
+		{0, 3, "0.000"},
+		{235100, 1, "23510.0"},
+		{3365, 0, "3365"},
+	}
+	for i, tt := range tests {
+		if actual := tt.f.Format(tt.precision); actual != tt.expected {
+			t.Errorf("#%d Format() = %v, expected: %v", i, actual, tt.expected)
+		}
+	}
+}
+
+// TestFixed_roundTrip parses prices as they are responded by OANDA API and formats them back.
+func TestFixed_roundTrip(t *testing.T) {
+	for _, s := range []string{"105.512", "105.400", "1.18478", "1.18600", "0.90625", "1885.245", "23510.0", "0.00050"} {
+		precision := decimalPlaces(s)
+		f, err := ParseFixed(s, precision)
+		if err != nil {
+			t.Fatalf("ParseFixed(%q) error = %v", s, err)
+		}
+		if actual := f.Format(precision); actual != s {
+			t.Errorf("Format(ParseFixed(%q)) = %v", s, actual)
+		}
+		if actual := ToFixed(f.Price(precision), precision); actual != f {
+			t.Errorf("ToFixed(Price(ParseFixed(%q))) = %v, expected: %v", s, actual, f)
+		}
+	}
+
+	// every price of 0.1 pips survives float64
+	for _, precision := range []int{3, 5} {
+		for f := Fixed(99000); f < 130000; f++ {
+			if actual := ToFixed(f.Price(precision), precision); actual != f {
+				t.Fatalf("ToFixed(Price(%v)) = %v in precision %d", f, actual, precision)
+			}
+		}
+	}
+}
+
+func TestParseOrderBook_precision(t *testing.T) {
+	body := []byte(`{"orderBook":{"instrument":"EUR_USD","time":"2020-10-01T00:20:00Z","price":"1.18478","bucketWidth":"0.00050",` +
+		`"buckets":[{"price":"1.1840","longCountPercent":"0.1","shortCountPercent":"0.2"},{"price":"1.1845","longCountPercent":"0","shortCountPercent":"0"}]}}`)
+	book, err := ParseOrderBook(body)
+	if err != nil {
+		t.Fatalf("ParseOrderBook() error = %v", err)
+	}
+	if book.Precision != 5 || book.Price != 1.18478 || book.Buckets[0].Price != 1.184 {
+		t.Errorf("ParseOrderBook() = %+v", book)
+	}
+	if actual := book.Buckets[1].Price.PriceStr(book.Instrument); actual != "1.18450" {
+		t.Errorf("PriceStr() = %v, expected: 1.18450", actual)
+	}
+
+	// a price finer than the display precision widens the precision
+	body = []byte(`{"orderBook":{"instrument":"USD_JPY","time":"2020-10-01T00:20:00Z","price":"105.5125","bucketWidth":"0.050",` +
+		`"buckets":[{"price":"105.500","longCountPercent":"0.1","shortCountPercent":"0.2"}]}}`)
+	if book, err = ParseOrderBook(body); err != nil {
+		t.Fatalf("ParseOrderBook() error = %v", err)
+	}
+	if book.Precision != 4 || book.Price != 105.5125 {
+		t.Errorf("ParseOrderBook() = %+v", book)
+	}
+}
+
+func TestBook_VicinityOfPrice_fixed(t *testing.T) {
+	// 1.18 + 0.0025 is 1.1824999999999999 in float64
+	computed := Price(1.18 + 0.0005*5)
+	o := &Book{Instrument: InstrumentEURUSD, Buckets: []BookBucket{{Price: 1.182}, {Price: 1.1825}, {Price: 1.183}}}
+	below, above := o.VicinityOfPrice(computed, 1)
+	if len(below) != 1 || below[0].Price != 1.1825 || len(above) != 1 || above[0].Price != 1.183 {
+		t.Errorf("VicinityOfPrice() = %v, %v, expected the bucket at the price below it", below, above)
+	}
+}
+
+func TestBook_fixed(t *testing.T) {
+	body := []byte(`{"orderBook":{"instrument":"EUR_USD","time":"2020-10-01T00:20:00Z","price":"1.18450","bucketWidth":"0.00050",` +
+		`"buckets":[{"price":"1.1840","longCountPercent":"0.1","shortCountPercent":"0.2"},{"price":"1.1845","longCountPercent":"0","shortCountPercent":"0"},` +
+		`{"price":"1.1850","longCountPercent":"0","shortCountPercent":"0"}]}}`)
+	o, err := ParseOrderBook(body)
+	if err != nil {
+		t.Fatalf("ParseOrderBook() error = %v", err)
+	}
+	if !o.exact || o.exactPrice != 118450 || o.exactWidth != 50 {
+		t.Errorf("ParseOrderBook() price = %v, width = %v, expected: 118450, 50", o.exactPrice, o.exactWidth)
+	}
+	for i, expected := range []Fixed{118400, 118450, 118500} {
+		if b := o.Buckets[i]; !b.exact || b.exactPrice != expected {
+			t.Errorf("#%d ParseOrderBook() bucket price = %v, expected: %v", i, b.exactPrice, expected)
+		}
+	}
+
+	// the bucket at the price is below it
+	below, above := o.Vicinity(1)
+	if len(below) != 1 || below[0].exactPrice != 118450 || len(above) != 1 || above[0].exactPrice != 118500 {
+		t.Errorf("Vicinity() = %v, %v, expected the bucket at the price below it", below, above)
+	}
+	if actual := o.DistanceToPrice(o.Buckets[2]); actual != 5 {
+		t.Errorf("DistanceToPrice() = %v, expected: 5", actual)
+	}
+	if actual := o.DistanceToPrice(o.Buckets[0]); actual != 0 {
+		t.Errorf("DistanceToPrice() = %v, expected: 0", actual)
+	}
+}
+
+func TestFixed_Rescale(t *testing.T) {
+	tests := []struct {
+		f        Fixed
+		from, to int
+		expected Fixed
+	}{
+		{105512, 3, 5, 10551200},
+		{105512, 3, 3, 105512},
+		{1055125, 4, 3, 105512},
+		{118478, 5, 4, 11847},
+		{-1055125, 4, 3, -105513},
+		{-1055120, 4, 3, -105512},
+	}
+	for i, tt := range tests {
+		if actual := tt.f.Rescale(tt.from, tt.to); actual != tt.expected {
+			t.Errorf("#%d Rescale() = %v, expected: %v", i, actual, tt.expected)
+		}
+	}
+}
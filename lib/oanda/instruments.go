@@ -1,5 +1,15 @@
 package oanda
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
 type Instrument string
 
 const (
@@ -15,24 +25,173 @@ const (
 	InstrumentUNKNOWN = Instrument("UNKNOWN")
 )
 
-var instrumentsMap = map[string]Instrument{
-	"USD_JPY": InstrumentUSDJPY,
-	"EUR_JPY": InstrumentEURJPY,
-	"AUD_JPY": InstrumentAUDJPY,
-	"GBP_JPY": InstrumentGBPJPY,
-	"EUR_USD": InstrumentEURUSD,
-	"GBP_USD": InstrumentGBPUSD,
-	"AUD_USD": InstrumentAUDUSD,
-	"NZD_USD": InstrumentNZDUSD,
-	"EUR_GBP": InstrumentEURGBP,
-	"UNKNOWN": InstrumentUNKNOWN,
+// instruments are the major instruments in the order of the constants.
+var instruments = []Instrument{
+	InstrumentUSDJPY,
+	InstrumentEURJPY,
+	InstrumentAUDJPY,
+	InstrumentGBPJPY,
+	InstrumentEURUSD,
+	InstrumentGBPUSD,
+	InstrumentAUDUSD,
+	InstrumentNZDUSD,
+	InstrumentEURGBP,
+}
+
+// InstrumentType is the type of an instrument in OANDA API.
+type InstrumentType string
+
+const (
+	InstrumentTypeCurrency = InstrumentType("CURRENCY")
+	InstrumentTypeCFD      = InstrumentType("CFD")
+	InstrumentTypeMetal    = InstrumentType("METAL")
+)
+
+// InstrumentSpec is the metadata of an instrument responded by /v3/accounts/{accountID}/instruments.
+type InstrumentSpec struct {
+	Name Instrument     `json:"name"`
+	Type InstrumentType `json:"type"`
+	// PipLocation is the location of a pip as a power of 10. -2 is 0.01.
+	PipLocation int `json:"pipLocation"`
+	// DisplayPrecision is the number of decimal places of prices.
+	DisplayPrecision int `json:"displayPrecision"`
+	// BucketWidth is the price range of a bucket of the books. It is 5 pips unless it is given.
+	BucketWidth Price `json:"-"`
+	// QuoteCurrency is the currency in which the price is quoted. It is the last part of the name
+	// unless it is given, such as JPY of USD_JPY.
+	QuoteCurrency string `json:"-"`
+}
+
+// complete fills BucketWidth and QuoteCurrency which are not given.
+func (s InstrumentSpec) complete() InstrumentSpec {
+	if s.BucketWidth == 0 {
+		s.BucketWidth = s.PipsToPrice(5)
+	}
+	if len(s.QuoteCurrency) == 0 {
+		name := string(s.Name)
+		s.QuoteCurrency = name[strings.LastIndex(name, "_")+1:]
+	}
+	return s
+}
+
+// builtinSpecs are the instruments known without OANDA API.
+var builtinSpecs = []InstrumentSpec{
+	{Name: InstrumentUSDJPY, Type: InstrumentTypeCurrency, PipLocation: -2, DisplayPrecision: 3},
+	{Name: InstrumentEURJPY, Type: InstrumentTypeCurrency, PipLocation: -2, DisplayPrecision: 3},
+	{Name: InstrumentAUDJPY, Type: InstrumentTypeCurrency, PipLocation: -2, DisplayPrecision: 3},
+	{Name: InstrumentGBPJPY, Type: InstrumentTypeCurrency, PipLocation: -2, DisplayPrecision: 3},
+	{Name: InstrumentEURUSD, Type: InstrumentTypeCurrency, PipLocation: -4, DisplayPrecision: 5},
+	{Name: InstrumentGBPUSD, Type: InstrumentTypeCurrency, PipLocation: -4, DisplayPrecision: 5},
+	{Name: InstrumentAUDUSD, Type: InstrumentTypeCurrency, PipLocation: -4, DisplayPrecision: 5},
+	{Name: InstrumentNZDUSD, Type: InstrumentTypeCurrency, PipLocation: -4, DisplayPrecision: 5},
+	{Name: InstrumentEURGBP, Type: InstrumentTypeCurrency, PipLocation: -4, DisplayPrecision: 5},
+	{Name: "USD_CHF", Type: InstrumentTypeCurrency, PipLocation: -4, DisplayPrecision: 5},
+	{Name: "USD_CAD", Type: InstrumentTypeCurrency, PipLocation: -4, DisplayPrecision: 5},
+	{Name: "EUR_CHF", Type: InstrumentTypeCurrency, PipLocation: -4, DisplayPrecision: 5},
+	{Name: "XAU_USD", Type: InstrumentTypeMetal, PipLocation: -2, DisplayPrecision: 3},
+	{Name: "XAG_USD", Type: InstrumentTypeMetal, PipLocation: -4, DisplayPrecision: 5},
+	{Name: "JP225_USD", Type: InstrumentTypeCFD, PipLocation: 0, DisplayPrecision: 1},
+	{Name: "US30_USD", Type: InstrumentTypeCFD, PipLocation: 0, DisplayPrecision: 1},
+	{Name: "SPX500_USD", Type: InstrumentTypeCFD, PipLocation: 0, DisplayPrecision: 1},
+	{Name: "NAS100_USD", Type: InstrumentTypeCFD, PipLocation: 0, DisplayPrecision: 1},
+}
+
+// registry holds the specs of the known instruments. It starts with builtinSpecs and
+// grows with Register.
+var registry = struct {
+	sync.RWMutex
+	specs map[Instrument]InstrumentSpec
+}{specs: map[Instrument]InstrumentSpec{}}
+
+func init() {
+	Register(builtinSpecs...)
+}
+
+// Register adds the specs to the known instruments. A spec of a known instrument replaces it.
+// BucketWidth and QuoteCurrency are filled if they are not given.
+func Register(specs ...InstrumentSpec) {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, s := range specs {
+		registry.specs[s.Name] = s.complete()
+	}
+}
+
+// LookupInstrument returns the spec of the instrument. ok is false if the instrument is unknown.
+func LookupInstrument(instrument Instrument) (spec InstrumentSpec, ok bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	spec, ok = registry.specs[instrument]
+	return spec, ok
 }
 
+// RegisteredInstruments returns the known instruments in the order of the names.
+func RegisteredInstruments() []Instrument {
+	registry.RLock()
+	defer registry.RUnlock()
+	var names []Instrument
+	for name := range registry.specs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// ToInstrument returns the instrument named str. It is InstrumentUNKNOWN unless the instrument is known.
 func ToInstrument(str string) Instrument {
-	for k, v := range instrumentsMap {
-		if k == str {
-			return v
-		}
+	if _, ok := LookupInstrument(Instrument(str)); ok {
+		return Instrument(str)
 	}
 	return InstrumentUNKNOWN
 }
+
+// Instruments returns the major instruments, which are the instruments of the constants except InstrumentUNKNOWN.
+func Instruments() []Instrument {
+	return append([]Instrument(nil), instruments...)
+}
+
+type retrievedInstruments struct {
+	Instruments []InstrumentSpec `json:"instruments"`
+}
+
+// ParseInstruments parses the response of /v3/accounts/{accountID}/instruments.
+func ParseInstruments(body []byte) ([]InstrumentSpec, error) {
+	var r retrievedInstruments
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("failed to json unmarshal instruments: %v", err)
+	}
+	return r.Instruments, nil
+}
+
+// FetchInstruments fetches the specs of the instruments tradeable in the account.
+func (c *Client) FetchInstruments(accountID string) ([]InstrumentSpec, error) {
+	return c.FetchInstrumentsContext(context.Background(), accountID)
+}
+
+// FetchInstrumentsContext is like FetchInstruments but gives up when ctx is done.
+// The specs are read from the cache of the client if they are cached within a day.
+func (c *Client) FetchInstrumentsContext(ctx context.Context, accountID string) ([]InstrumentSpec, error) {
+	if c.cache != nil {
+		body, ok, err := c.cache.LoadInstruments(accountID)
+		if err != nil {
+			log.Printf("failed to load instruments from cache: %v", err)
+		}
+		if ok {
+			return ParseInstruments(body)
+		}
+	}
+	body, err := c.get(ctx, c.endpoint+"/v3/accounts/"+accountID+"/instruments")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch instruments: %w", err)
+	}
+	specs, err := ParseInstruments(body)
+	if err != nil {
+		return nil, err
+	}
+	if c.cache != nil {
+		if err := c.cache.StoreInstruments(accountID, body); err != nil {
+			log.Printf("failed to store instruments in cache: %v", err)
+		}
+	}
+	return specs, nil
+}
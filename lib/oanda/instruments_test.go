@@ -0,0 +1,78 @@
+package oanda
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda/oandatest"
+)
+
+func TestToInstrument(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected Instrument
+	}{
+		{"USD_JPY", InstrumentUSDJPY},
+		{"EUR_GBP", InstrumentEURGBP},
+		{"XAU_USD", Instrument("XAU_USD")},
+		{"JP225_USD", Instrument("JP225_USD")},
+		{"USD_XXX", InstrumentUNKNOWN},
+		{"UNKNOWN", InstrumentUNKNOWN},
+		{"", InstrumentUNKNOWN},
+	}
+	for i, tt := range tests {
+		if actual := ToInstrument(tt.str); actual != tt.expected {
+			t.Errorf("#%d ToInstrument(%q) = %v, expected: %v", i, tt.str, actual, tt.expected)
+		}
+	}
+}
+
+func TestClient_FetchInstruments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oanda-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv := oandatest.NewServer()
+	defer srv.Close()
+	srv.SetInstruments("001-001-1-001",
+		oandatest.Instrument{Name: "USD_TRY", Type: "CURRENCY", PipLocation: -4, DisplayPrecision: 5},
+		oandatest.Instrument{Name: "DE30_EUR", Type: "CFD", PipLocation: 0, DisplayPrecision: 1},
+	)
+	expected := []InstrumentSpec{
+		{Name: "USD_TRY", Type: InstrumentTypeCurrency, PipLocation: -4, DisplayPrecision: 5},
+		{Name: "DE30_EUR", Type: InstrumentTypeCFD, PipLocation: 0, DisplayPrecision: 1},
+	}
+
+	c := NewClient("key", WithBaseURL(srv.URL), WithRetryPolicy(NoRetry), WithCache(NewCache(dir)))
+	for i := 0; i < 2; i++ {
+		specs, err := c.FetchInstruments("001-001-1-001")
+		if err != nil {
+			t.Fatalf("FetchInstruments() error = %v", err)
+		}
+		if !reflect.DeepEqual(specs, expected) {
+			t.Errorf("FetchInstruments() = %+v, expected: %+v", specs, expected)
+		}
+	}
+	// the instruments are fetched once and read from the cache
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("Requests() = %d, want 1", n)
+	}
+	if _, err := c.FetchInstruments("001-001-1-002"); err == nil {
+		t.Errorf("FetchInstruments() error = nil, expected an error of the unknown account")
+	}
+
+	if ToInstrument("USD_TRY") != InstrumentUNKNOWN {
+		t.Fatalf("ToInstrument() = USD_TRY before Register()")
+	}
+	Register(expected...)
+	if actual := ToInstrument("USD_TRY"); actual != "USD_TRY" {
+		t.Errorf("ToInstrument() = %v after Register(), expected: USD_TRY", actual)
+	}
+	if actual := Pips(10).PipsToPrice("DE30_EUR"); actual != 10 {
+		t.Errorf("PipsToPrice() = %v, expected: 10", actual)
+	}
+}
@@ -0,0 +1,278 @@
+// Package oandatest provides a fake OANDA API server for tests.
+package oandatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Granularity is the interval of snapshots of order books and position books.
+const Granularity = 20 * time.Minute
+
+// Bucket is a bucket of a fixture book. The values are sent as they are.
+type Bucket struct {
+	Price             string `json:"price"`
+	LongCountPercent  string `json:"longCountPercent"`
+	ShortCountPercent string `json:"shortCountPercent"`
+}
+
+// Book is a fixture of an order book or a position book.
+type Book struct {
+	Instrument  string    `json:"instrument"`
+	Time        time.Time `json:"time"`
+	Price       string    `json:"price"`
+	BucketWidth string    `json:"bucketWidth"`
+	Buckets     []Bucket  `json:"buckets"`
+}
+
+// NewBook builds a book at t whose buckets of the given width surround price.
+// n empty buckets are built on each side of price.
+func NewBook(instrument string, t time.Time, price, width string, n int) Book {
+	p, err := strconv.ParseFloat(price, 64)
+	if err != nil {
+		panic(fmt.Sprintf("oandatest: invalid price %q: %v", price, err))
+	}
+	w, err := strconv.ParseFloat(width, 64)
+	if err != nil || w <= 0 {
+		panic(fmt.Sprintf("oandatest: invalid bucket width %q", width))
+	}
+	digits := 0
+	if i := strings.Index(width, "."); i >= 0 {
+		digits = len(width) - i - 1
+	}
+	lowest := math.Floor(p/w) - float64(n-1)
+	b := Book{
+		Instrument:  instrument,
+		Time:        t.UTC(),
+		Price:       price,
+		BucketWidth: width,
+	}
+	for i := 0; i < 2*n; i++ {
+		b.Buckets = append(b.Buckets, Bucket{
+			Price:             strconv.FormatFloat((lowest+float64(i))*w, 'f', digits, 64),
+			LongCountPercent:  "0.0000",
+			ShortCountPercent: "0.0000",
+		})
+	}
+	return b
+}
+
+// Set sets percentages of the bucket whose price is price. It panics if the bucket does not exist.
+func (b *Book) Set(price string, long, short float64) *Book {
+	for i := range b.Buckets {
+		if b.Buckets[i].Price == price {
+			b.Buckets[i].LongCountPercent = strconv.FormatFloat(long, 'f', 4, 64)
+			b.Buckets[i].ShortCountPercent = strconv.FormatFloat(short, 'f', 4, 64)
+			return b
+		}
+	}
+	panic(fmt.Sprintf("oandatest: bucket %s does not exist", price))
+}
+
+// Instrument is a fixture of an instrument of an account.
+type Instrument struct {
+	Name             string `json:"name"`
+	Type             string `json:"type"`
+	PipLocation      int    `json:"pipLocation"`
+	DisplayPrecision int    `json:"displayPrecision"`
+}
+
+type bookKind string
+
+const (
+	orderBook    = bookKind("orderBook")
+	positionBook = bookKind("positionBook")
+)
+
+type key struct {
+	instrument string
+	kind       bookKind
+	time       time.Time
+}
+
+type injectedError struct {
+	status     int
+	retryAfter string
+}
+
+// Server is a fake OANDA API which serves /v3/instruments/{instrument}/orderBook and
+// /v3/instruments/{instrument}/positionBook from fixture books, and
+// /v3/accounts/{accountID}/instruments from fixture instruments.
+// The time parameter is truncated to Granularity to look up the snapshot.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	books    map[key]Book
+	accounts map[string][]Instrument
+	latency  time.Duration
+	errors   []injectedError
+	requests []*http.Request
+}
+
+// NewServer starts a fake OANDA API without fixtures. The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{books: map[key]Book{}, accounts: map[string][]Instrument{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// AddOrderBook registers b as the order book snapshot at b.Time.
+func (s *Server) AddOrderBook(b Book) {
+	s.add(orderBook, b)
+}
+
+// AddPositionBook registers b as the position book snapshot at b.Time.
+func (s *Server) AddPositionBook(b Book) {
+	s.add(positionBook, b)
+}
+
+func (s *Server) add(kind bookKind, b Book) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.books[key{b.Instrument, kind, b.Time.UTC().Truncate(Granularity)}] = b
+}
+
+// SetInstruments registers the instruments of the account.
+func (s *Server) SetInstruments(accountID string, instruments ...Instrument) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[accountID] = instruments
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// FailNext makes the next n requests fail with status.
+// retryAfter is sent as Retry-After header unless it is empty.
+func (s *Server) FailNext(n int, status int, retryAfter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.errors = append(s.errors, injectedError{status, retryAfter})
+	}
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	latency := s.latency
+	var injected *injectedError
+	if len(s.errors) > 0 {
+		injected = &s.errors[0]
+		s.errors = s.errors[1:]
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if injected != nil {
+		if len(injected.retryAfter) > 0 {
+			w.Header().Set("Retry-After", injected.retryAfter)
+		}
+		writeError(w, injected.status, http.StatusText(injected.status))
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "Insufficient authorization to perform request.")
+		return
+	}
+
+	// /v3/accounts/{accountID}/instruments
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 4 && parts[0] == "v3" && parts[1] == "accounts" && parts[3] == "instruments" {
+		s.mu.Lock()
+		instruments, ok := s.accounts[parts[2]]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusForbidden, "The provided request was forbidden.")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string][]Instrument{"instruments": instruments})
+		return
+	}
+
+	// /v3/instruments/{instrument}/{kind}
+	if len(parts) != 4 || parts[0] != "v3" || parts[1] != "instruments" {
+		writeError(w, http.StatusNotFound, "unknown endpoint: "+r.URL.Path)
+		return
+	}
+	instrument, kind := parts[2], bookKind(parts[3])
+	if kind != orderBook && kind != positionBook {
+		writeError(w, http.StatusNotFound, "unknown endpoint: "+r.URL.Path)
+		return
+	}
+
+	var at *time.Time
+	if v := r.URL.Query().Get("time"); len(v) > 0 {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid value specified for 'time'")
+			return
+		}
+		at = &t
+	}
+	b, ok := s.lookup(instrument, kind, at)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No %s found for %s", kind, instrument))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[bookKind]Book{kind: b})
+}
+
+// lookup finds the snapshot at t, or the latest snapshot if t is nil.
+func (s *Server) lookup(instrument string, kind bookKind, t *time.Time) (Book, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t != nil {
+		b, ok := s.books[key{instrument, kind, t.UTC().Truncate(Granularity)}]
+		return b, ok
+	}
+	var latest []Book
+	for k, b := range s.books {
+		if k.instrument == instrument && k.kind == kind {
+			latest = append(latest, b)
+		}
+	}
+	if len(latest) == 0 {
+		return Book{}, false
+	}
+	sort.Slice(latest, func(i, j int) bool { return latest[i].Time.After(latest[j].Time) })
+	return latest[0], true
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"errorMessage": message})
+}
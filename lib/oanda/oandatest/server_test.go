@@ -0,0 +1,79 @@
+package oandatest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda/oandatest"
+)
+
+func TestServer(t *testing.T) {
+	at := time.Date(2020, 10, 1, 0, 20, 0, 0, time.UTC)
+	srv := oandatest.NewServer()
+	defer srv.Close()
+	ob := oandatest.NewBook("USD_JPY", at, "105.512", "0.05", 3)
+	ob.Set("105.50", 0.3, 0.7)
+	srv.AddOrderBook(ob)
+	srv.AddPositionBook(oandatest.NewBook("USD_JPY", at, "105.512", "0.05", 3))
+
+	c := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.NoRetry))
+	requested := at.Add(7 * time.Minute)
+	book, err := c.FetchOrderBook(oanda.InstrumentUSDJPY, &requested)
+	if err != nil {
+		t.Fatalf("FetchOrderBook() error = %v", err)
+	}
+	if !book.Time.Equal(at) || book.Price != 105.512 || len(book.Buckets) != 6 {
+		t.Errorf("FetchOrderBook() = %+v", book)
+	}
+	if b := book.Buckets[2]; b.Price != 105.50 || b.LongCountPercent != 0.3 || b.ShortCountPercent != 0.7 {
+		t.Errorf("FetchOrderBook() bucket[2] = %+v", b)
+	}
+	if _, err := c.FetchPositionBook(oanda.InstrumentUSDJPY, nil); err != nil {
+		t.Errorf("FetchPositionBook() error = %v", err)
+	}
+
+	missing := at.Add(oandatest.Granularity)
+	_, err = c.FetchOrderBook(oanda.InstrumentUSDJPY, &missing)
+	var e *oanda.Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusNotFound {
+		t.Errorf("FetchOrderBook() error = %v, want 404", err)
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Errorf("Requests() = %d, want 3", n)
+	}
+}
+
+func TestServer_FailNext(t *testing.T) {
+	at := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	srv := oandatest.NewServer()
+	defer srv.Close()
+	srv.AddOrderBook(oandatest.NewBook("EUR_USD", at, "1.17234", "0.0005", 3))
+	srv.FailNext(2, http.StatusServiceUnavailable, "0")
+
+	c := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.RetryPolicy{MaxRetries: 2}))
+	if _, err := c.FetchOrderBook(oanda.InstrumentEURUSD, &at); err != nil {
+		t.Errorf("FetchOrderBook() error = %v", err)
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Errorf("Requests() = %d, want 3", n)
+	}
+}
+
+func TestServer_SetLatency(t *testing.T) {
+	at := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	srv := oandatest.NewServer()
+	defer srv.Close()
+	srv.AddOrderBook(oandatest.NewBook("EUR_USD", at, "1.17234", "0.0005", 3))
+	srv.SetLatency(time.Second)
+
+	c := oanda.NewClient("key", oanda.WithBaseURL(srv.URL))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.FetchOrderBookContext(ctx, oanda.InstrumentEURUSD, &at); err == nil {
+		t.Errorf("FetchOrderBookContext() error = nil, want timeout")
+	}
+}
@@ -0,0 +1,149 @@
+package oanda
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Environment is an OANDA environment to which the client connects.
+type Environment int
+
+const (
+	EnvironmentPractice Environment = iota
+	EnvironmentTrade
+)
+
+// ParseEnvironment converts "practice" or "trade" to Environment.
+func ParseEnvironment(str string) (Environment, error) {
+	switch strings.ToLower(str) {
+	case "practice":
+		return EnvironmentPractice, nil
+	case "trade":
+		return EnvironmentTrade, nil
+	}
+	return EnvironmentPractice, fmt.Errorf("unknown environment: %s (practice or trade)", str)
+}
+
+func (e Environment) String() string {
+	if e == EnvironmentTrade {
+		return "trade"
+	}
+	return "practice"
+}
+
+// BaseURL returns the REST API endpoint of the environment.
+func (e Environment) BaseURL() string {
+	if e == EnvironmentTrade {
+		return "https://api-fxtrade.oanda.com"
+	}
+	return "https://api-fxpractice.oanda.com"
+}
+
+// DefaultUserAgent is the User-Agent sent by clients built without WithUserAgent.
+const DefaultUserAgent = "order-book-searcher"
+
+type options struct {
+	environment Environment
+	baseURL     string
+	httpClient  *http.Client
+	transport   http.RoundTripper
+	userAgent   string
+	retryPolicy RetryPolicy
+	rateLimit   float64
+	burst       int
+	timeout     *time.Duration
+	cache       *Cache
+}
+
+// Option configures Client built by NewClient.
+type Option func(*options)
+
+// WithEnvironment selects the OANDA environment. Defaults to EnvironmentPractice.
+func WithEnvironment(env Environment) Option {
+	return func(o *options) { o.environment = env }
+}
+
+// WithBaseURL overrides the endpoint of the environment, e.g. to connect to a local fake server.
+func WithBaseURL(url string) Option {
+	return func(o *options) { o.baseURL = strings.TrimRight(url, "/") }
+}
+
+// WithHTTPClient makes the client send requests through a copy of c.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) { o.httpClient = c }
+}
+
+// WithTransport replaces the transport of the underlying http.Client.
+func WithTransport(t http.RoundTripper) Option {
+	return func(o *options) { o.transport = t }
+}
+
+// WithUserAgent sets the User-Agent header of every request.
+func WithUserAgent(ua string) Option {
+	return func(o *options) { o.userAgent = ua }
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *options) { o.retryPolicy = p }
+}
+
+// WithRateLimit limits requests to rate per second with the given burst.
+// Requests are not limited if rate is not positive.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(o *options) {
+		o.rateLimit = rate
+		o.burst = burst
+	}
+}
+
+// WithTimeout sets the time limit of each request including reading the response body.
+// A timeout of zero means no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.timeout = &timeout }
+}
+
+// WithCache serves historical books from cache and stores fetched ones in it.
+// Books requested for the last hour are never cached because they may still change.
+// The books are stored apart for each host of the endpoint.
+func WithCache(cache *Cache) Option {
+	return func(o *options) { o.cache = cache }
+}
+
+func buildOptions(opts []Option) options {
+	o := options{
+		environment: EnvironmentPractice,
+		userAgent:   DefaultUserAgent,
+		retryPolicy: DefaultRetryPolicy,
+		rateLimit:   DefaultRateLimit,
+		burst:       DefaultBurst,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o *options) endpoint() string {
+	if len(o.baseURL) > 0 {
+		return o.baseURL
+	}
+	return o.environment.BaseURL()
+}
+
+func (o *options) client() *http.Client {
+	c := &http.Client{Timeout: DefaultTimeout}
+	if o.httpClient != nil {
+		copied := *o.httpClient
+		c = &copied
+	}
+	if o.transport != nil {
+		c.Transport = o.transport
+	}
+	if o.timeout != nil {
+		c.Timeout = *o.timeout
+	}
+	return c
+}
@@ -9,50 +9,88 @@ type Price float64
 
 type Pips float64 // valid up to the first minority
 
+// PipsToPrice converts p, which is rounded up to a pip, to the price difference in the instrument.
+// It returns 0 if the instrument is unknown.
 func (p Pips) PipsToPrice(instrument Instrument) Price {
-	if instrument == InstrumentUSDJPY {
-		return Price(math.Ceil(float64(p)) / 100)
+	spec, ok := LookupInstrument(instrument)
+	if !ok {
+		return 0
 	}
-	if instrument == InstrumentEURJPY {
-		return Price(math.Ceil(float64(p)) / 100)
-	}
-	if instrument == InstrumentAUDJPY {
-		return Price(math.Ceil(float64(p)) / 100)
-	}
-	if instrument == InstrumentGBPJPY {
-		return Price(math.Ceil(float64(p)) / 100)
-	}
-	if instrument == InstrumentEURUSD {
-		return Price(math.Ceil(float64(p)) / 10000)
-	}
-	if instrument == InstrumentGBPUSD {
-		return Price(math.Ceil(float64(p)) / 10000)
+	return spec.PipsToPrice(p)
+}
+
+// PriceToPips converts the price difference p to pips rounded to 0.1 pips.
+// It returns 0 if the instrument is unknown.
+func (p Price) PriceToPips(instrument Instrument) Pips {
+	spec, ok := LookupInstrument(instrument)
+	if !ok {
+		return 0
 	}
-	if instrument == InstrumentAUDUSD {
-		return Price(math.Ceil(float64(p)) / 10000)
+	return spec.PriceToPips(p)
+}
+
+// Round rounds p to 0.1 pips. p is returned as it is if the instrument is unknown.
+func (p Price) Round(instrument Instrument) Price {
+	spec, ok := LookupInstrument(instrument)
+	if !ok {
+		return p
 	}
-	if instrument == InstrumentNZDUSD {
-		return Price(math.Ceil(float64(p)) / 10000)
+	return spec.Round(p)
+}
+
+// RoundFivePips rounds p to 5 pips. p is returned as it is if the instrument is unknown.
+func (p Price) RoundFivePips(instrument Instrument) Price {
+	spec, ok := LookupInstrument(instrument)
+	if !ok {
+		return p
 	}
-	if instrument == InstrumentEURGBP {
-		return Price(math.Ceil(float64(p)) / 10000)
+	return spec.RoundFivePips(p)
+}
+
+// PriceStr converts string price in the display precision of the instrument.
+// The shortest representation of p is returned if the instrument is unknown.
+func (p Price) PriceStr(instrument Instrument) string {
+	spec, ok := LookupInstrument(instrument)
+	if !ok {
+		return strconv.FormatFloat(float64(p), 'f', -1, 64)
 	}
-	return 0
+	return spec.PriceStr(p)
 }
 
-func (p Price) Round(instrument Instrument) Price {
-	r := 10 / float64(Pips(1).PipsToPrice(instrument))
+// pipsPerUnit is the number of pips in 1 of the price. Prices are divided by it rather than
+// multiplied by a pip, which is not exact in float64.
+func (s InstrumentSpec) pipsPerUnit() float64 {
+	return math.Pow10(-s.PipLocation)
+}
+
+// Pip returns the price difference of a pip.
+func (s InstrumentSpec) Pip() Price {
+	return Price(1 / s.pipsPerUnit())
+}
+
+// PipsToPrice converts p, which is rounded up to a pip, to the price difference.
+func (s InstrumentSpec) PipsToPrice(p Pips) Price {
+	return Price(math.Ceil(float64(p)) / s.pipsPerUnit())
+}
+
+// PriceToPips converts the price difference p to pips rounded to 0.1 pips.
+func (s InstrumentSpec) PriceToPips(p Price) Pips {
+	return Pips(math.Round(float64(p)*s.pipsPerUnit()*10) / 10)
+}
+
+// Round rounds p to 0.1 pips.
+func (s InstrumentSpec) Round(p Price) Price {
+	r := s.pipsPerUnit() * 10
 	return Price(math.Round(float64(p)*r) / r)
 }
 
-func (p Price) RoundFivePips(instrument Instrument) Price {
-	r := float64(Pips(1).PipsToPrice(instrument) * 10)
-	return Price(math.Round(float64(p)*2/r) * r / 2).Round(instrument)
+// RoundFivePips rounds p to 5 pips.
+func (s InstrumentSpec) RoundFivePips(p Price) Price {
+	r := s.pipsPerUnit() / 5
+	return s.Round(Price(math.Round(float64(p)*r) / r))
 }
 
-// PriceStr converts string price. (0.1 pips units)
-func (p Price) PriceStr(instrument Instrument) string {
-	r := math.Floor(1 / float64(Pips(1).PipsToPrice(instrument)))
-	rDigits := int(math.Log10(r)) + 1
-	return strconv.FormatFloat(float64(p), 'f', rDigits, 64)
+// PriceStr converts string price in the display precision.
+func (s InstrumentSpec) PriceStr(p Price) string {
+	return ToFixed(p, s.DisplayPrecision).Format(s.DisplayPrecision)
 }
@@ -67,3 +67,150 @@ func TestPrice_RoundFivePips(t *testing.T) {
 		}
 	}
 }
+
+func TestPrice_PriceToPips(t *testing.T) {
+	type inputs struct {
+		price      Price
+		instrument Instrument
+	}
+	tests := []struct {
+		input    inputs
+		expected Pips
+	}{
+		{
+			input:    inputs{Price(105.512 - 105.40), InstrumentUSDJPY},
+			expected: Pips(11.2),
+		},
+		{
+			input:    inputs{Price(0.05), InstrumentEURJPY},
+			expected: Pips(5),
+		},
+		{
+			input:    inputs{Price(1.18478 - 1.1840), InstrumentEURUSD},
+			expected: Pips(7.8),
+		},
+		{
+			input:    inputs{Price(-0.00105), InstrumentEURGBP},
+			expected: Pips(-10.5),
+		},
+		{
+			input:    inputs{Price(1885.25 - 1884.10), Instrument("XAU_USD")},
+			expected: Pips(115),
+		},
+		{
+			input:    inputs{Price(23510 - 23480), Instrument("JP225_USD")},
+			expected: Pips(30),
+		},
+		{
+			input:    inputs{Price(1), InstrumentUNKNOWN},
+			expected: Pips(0),
+		},
+	}
+	for i, test := range tests {
+		if actual := test.input.price.PriceToPips(test.input.instrument); actual != test.expected {
+			t.Errorf("#%d PriceToPips() = %v, expected: %v", i, actual, test.expected)
+		}
+	}
+}
+
+func TestPrice_PriceStr(t *testing.T) {
+	type inputs struct {
+		price      Price
+		instrument Instrument
+	}
+	tests := []struct {
+		input    inputs
+		expected string
+	}{
+		{inputs{Price(105.4), InstrumentUSDJPY}, "105.400"},
+		{inputs{Price(1.186), InstrumentEURUSD}, "1.18600"},
+		{inputs{Price(1885.25), Instrument("XAU_USD")}, "1885.250"},
+		{inputs{Price(23510), Instrument("JP225_USD")}, "23510.0"},
+	}
+	for i, test := range tests {
+		if actual := test.input.price.PriceStr(test.input.instrument); actual != test.expected {
+			t.Errorf("#%d PriceStr() = %v, expected: %v", i, actual, test.expected)
+		}
+	}
+}
+
+func TestInstrumentSpec(t *testing.T) {
+	tests := []struct {
+		instrument Instrument
+		price      Price
+		// pip, 10 pips and the price difference of 12.3 pips
+		pip, tenPips, diff Price
+		round, fivePips    Price
+		str                string
+	}{
+		{InstrumentUSDJPY, 105.51249, 0.01, 0.1, 0.123, 105.512, 105.5, "105.512"},
+		{InstrumentEURJPY, 124.2776, 0.01, 0.1, 0.123, 124.278, 124.3, "124.278"},
+		{InstrumentEURUSD, 1.184776, 0.0001, 0.001, 0.00123, 1.18478, 1.185, "1.18478"},
+		{InstrumentEURGBP, 0.906249, 0.0001, 0.001, 0.00123, 0.90625, 0.906, "0.90625"},
+		{"XAU_USD", 1885.2449, 0.01, 0.1, 0.123, 1885.245, 1885.25, "1885.245"},
+		{"XAG_USD", 24.06784, 0.0001, 0.001, 0.00123, 24.06784, 24.068, "24.06784"},
+		{"JP225_USD", 23512.46, 1, 10, 12.3, 23512.5, 23510, "23512.5"},
+		{"SPX500_USD", 3365.04, 1, 10, 12.3, 3365.0, 3365, "3365.0"},
+	}
+	for i, tt := range tests {
+		spec, ok := LookupInstrument(tt.instrument)
+		if !ok {
+			t.Fatalf("#%d LookupInstrument(%v) is not found", i, tt.instrument)
+		}
+		if actual := spec.Pip(); actual != tt.pip {
+			t.Errorf("#%d Pip() = %v, expected: %v", i, actual, tt.pip)
+		}
+		if actual := spec.PipsToPrice(9.2); actual != tt.tenPips {
+			t.Errorf("#%d PipsToPrice() = %v, expected: %v", i, actual, tt.tenPips)
+		}
+		if actual := spec.PriceToPips(tt.diff); actual != 12.3 {
+			t.Errorf("#%d PriceToPips() = %v, expected: 12.3", i, actual)
+		}
+		if actual := spec.Round(tt.price); actual != tt.round {
+			t.Errorf("#%d Round() = %v, expected: %v", i, actual, tt.round)
+		}
+		if actual := spec.RoundFivePips(tt.price); actual != tt.fivePips {
+			t.Errorf("#%d RoundFivePips() = %v, expected: %v", i, actual, tt.fivePips)
+		}
+		if actual := spec.PriceStr(tt.price); actual != tt.str {
+			t.Errorf("#%d PriceStr() = %v, expected: %v", i, actual, tt.str)
+		}
+		if actual := spec.PipsToPrice(5); spec.BucketWidth != actual {
+			t.Errorf("#%d BucketWidth = %v, expected: %v", i, spec.BucketWidth, actual)
+		}
+	}
+}
+
+func TestInstrumentSpec_QuoteCurrency(t *testing.T) {
+	tests := []struct {
+		instrument Instrument
+		expected   string
+	}{
+		{InstrumentUSDJPY, "JPY"},
+		{InstrumentEURUSD, "USD"},
+		{InstrumentEURGBP, "GBP"},
+		{"XAU_USD", "USD"},
+		{"JP225_USD", "USD"},
+	}
+	for i, tt := range tests {
+		if spec, _ := LookupInstrument(tt.instrument); spec.QuoteCurrency != tt.expected {
+			t.Errorf("#%d QuoteCurrency = %v, expected: %v", i, spec.QuoteCurrency, tt.expected)
+		}
+	}
+}
+
+func TestPrice_unknownInstrument(t *testing.T) {
+	p := Price(1.2345678)
+	if actual := p.Round(InstrumentUNKNOWN); actual != p {
+		t.Errorf("Round() = %v, expected: %v", actual, p)
+	}
+	if actual := p.RoundFivePips(InstrumentUNKNOWN); actual != p {
+		t.Errorf("RoundFivePips() = %v, expected: %v", actual, p)
+	}
+	if actual := p.PriceStr(InstrumentUNKNOWN); actual != "1.2345678" {
+		t.Errorf("PriceStr() = %v, expected: 1.2345678", actual)
+	}
+	if actual := Pips(10).PipsToPrice(InstrumentUNKNOWN); actual != 0 {
+		t.Errorf("PipsToPrice() = %v, expected: 0", actual)
+	}
+}
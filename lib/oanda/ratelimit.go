@@ -0,0 +1,62 @@
+package oanda
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateLimit is the number of requests per second allowed by clients built with NewClient.
+	DefaultRateLimit = 10
+	// DefaultBurst is the number of requests which can be sent at once by clients built with NewClient.
+	DefaultBurst = 5
+)
+
+// rateLimiter is a token bucket shared by every request of a client.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // capacity of the bucket
+	tokens float64 // may be negative while waiters have reserved future tokens
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait before using it.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait blocks until a request is allowed or ctx is done. A nil limiter never blocks.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	return sleep(ctx, l.reserve(time.Now()))
+}
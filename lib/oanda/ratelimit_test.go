@@ -0,0 +1,63 @@
+package oanda
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_reserve(t *testing.T) {
+	now := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		rate     float64
+		burst    int
+		elapsed  []time.Duration // elapsed time since the previous reservation
+		expected []time.Duration
+	}{
+		{
+			rate:     10,
+			burst:    1,
+			elapsed:  []time.Duration{0, 0, 0},
+			expected: []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			rate:     10,
+			burst:    3,
+			elapsed:  []time.Duration{0, 0, 0, 0},
+			expected: []time.Duration{0, 0, 0, 100 * time.Millisecond},
+		},
+		{
+			rate:     10,
+			burst:    1,
+			elapsed:  []time.Duration{0, 100 * time.Millisecond, 50 * time.Millisecond},
+			expected: []time.Duration{0, 0, 50 * time.Millisecond},
+		},
+		{
+			rate:     2,
+			burst:    2,
+			elapsed:  []time.Duration{0, 0, 10 * time.Second, 0, 0},
+			expected: []time.Duration{0, 0, 0, 0, 500 * time.Millisecond},
+		},
+	}
+	for i, test := range tests {
+		l := newRateLimiter(test.rate, test.burst)
+		l.last = now
+		at := now
+		for j, e := range test.elapsed {
+			at = at.Add(e)
+			if actual := l.reserve(at); actual != test.expected[j] {
+				t.Errorf("#%d-%d reserve() = %v, expected: %v", i, j, actual, test.expected[j])
+			}
+		}
+	}
+}
+
+func TestNewRateLimiter_disabled(t *testing.T) {
+	l := newRateLimiter(0, 5)
+	if l != nil {
+		t.Errorf("newRateLimiter() = %v, expected: nil", l)
+	}
+	if err := l.wait(context.Background()); err != nil {
+		t.Errorf("wait() error = %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package oanda
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how the client retries retriable failures.
+type RetryPolicy struct {
+	MaxRetries int           // number of retries after the first attempt
+	BaseDelay  time.Duration // delay before the first retry
+	MaxDelay   time.Duration // upper limit of the backoff delay and Retry-After, not limited if 0
+}
+
+// maxRetryAfter is the upper limit of Retry-After of a policy whose MaxDelay is 0,
+// so that a broken Retry-After does not stall the client.
+const maxRetryAfter = time.Minute
+
+// DefaultRetryPolicy is the retry policy used by clients built with NewClient.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 5,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   30 * time.Second,
+}
+
+// NoRetry disables retries.
+var NoRetry = RetryPolicy{}
+
+// backoff returns the jittered delay before the retry following the given attempt (0 origin).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && d < math.MaxInt64/2; i++ {
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// delay returns the delay before retrying the request which failed with err.
+// Retry-After sent by OANDA takes precedence over the backoff. It is limited by MaxDelay,
+// or by maxRetryAfter if MaxDelay is 0.
+func (p RetryPolicy) delay(attempt int, err *Error) time.Duration {
+	if err.RetryAfter > 0 {
+		limit := p.MaxDelay
+		if limit <= 0 {
+			limit = maxRetryAfter
+		}
+		if err.RetryAfter > limit {
+			return limit
+		}
+		return err.RetryAfter
+	}
+	return p.backoff(attempt)
+}
@@ -0,0 +1,144 @@
+package search
+
+import "strings"
+
+// And fires on a side of the snapshot where every condition fires.
+// Its matches are the matches of the conditions on the side. The conditions are composed on
+// each side before ReportNearest keeps the nearer side of the composed matches.
+type And struct {
+	Conditions []Condition
+}
+
+func (c And) Name() string { return joinNames(c.Conditions, " && ") }
+
+func (c And) Match(s *Snapshot) []Match { return s.nearerSide(c.matchSides(s)) }
+
+func (c And) matchSides(s *Snapshot) []Match {
+	if len(c.Conditions) == 0 {
+		return nil
+	}
+	results := matchAll(c.Conditions, s)
+	var matches []Match
+	for _, side := range sides {
+		var ms []Match
+		for _, r := range results {
+			if len(r[side]) == 0 {
+				ms = nil
+				break
+			}
+			ms = append(ms, r[side]...)
+		}
+		matches = append(matches, combine(ms)...)
+	}
+	return matches
+}
+
+// Or fires on a side of the snapshot where any of the conditions fires.
+// Its matches are the matches of the conditions on the side.
+type Or struct {
+	Conditions []Condition
+}
+
+func (c Or) Name() string { return joinNames(c.Conditions, " || ") }
+
+func (c Or) Match(s *Snapshot) []Match { return s.nearerSide(c.matchSides(s)) }
+
+func (c Or) matchSides(s *Snapshot) []Match {
+	results := matchAll(c.Conditions, s)
+	var matches []Match
+	for _, side := range sides {
+		var ms []Match
+		for _, r := range results {
+			ms = append(ms, r[side]...)
+		}
+		matches = append(matches, combine(ms)...)
+	}
+	return matches
+}
+
+// Not fires on a side of the snapshot where the condition does not fire.
+// Its match has no buckets.
+type Not struct {
+	Condition Condition
+}
+
+func (c Not) Name() string { return "!" + c.Condition.Name() }
+
+func (c Not) Match(s *Snapshot) []Match { return s.nearerSide(c.matchSides(s)) }
+
+func (c Not) matchSides(s *Snapshot) []Match {
+	fired := bySide(matchSides(c.Condition, s))
+	var matches []Match
+	for _, side := range sides {
+		if len(fired[side]) == 0 {
+			matches = append(matches, s.match(side, 0, []string{c.Name()}, nil))
+		}
+	}
+	return matches
+}
+
+// joinNames joins the names of conditions with sep in parentheses.
+func joinNames(conditions []Condition, sep string) string {
+	var names []string
+	for _, c := range conditions {
+		names = append(names, c.Name())
+	}
+	return "(" + strings.Join(names, sep) + ")"
+}
+
+// matchAll returns the matches of each condition by side before the nearer side is kept.
+func matchAll(conditions []Condition, s *Snapshot) []map[Side][]Match {
+	var results []map[Side][]Match
+	for _, c := range conditions {
+		results = append(results, bySide(matchSides(c, s)))
+	}
+	return results
+}
+
+func bySide(matches []Match) map[Side][]Match {
+	m := map[Side][]Match{}
+	for _, match := range matches {
+		m[match.Side] = append(m[match.Side], match)
+	}
+	return m
+}
+
+// combine merges the matches fired on the same side into the matches of a composed condition.
+// Every match records all of the conditions fired. A window matched by several conditions is
+// reported once. Matches without buckets are dropped unless all of them are without buckets,
+// in which case one of them is kept.
+func combine(matches []Match) []Match {
+	if len(matches) == 0 {
+		return nil
+	}
+	var conditions []string
+	seen := map[string]bool{}
+	for _, m := range matches {
+		for _, c := range m.Conditions {
+			if !seen[c] {
+				seen[c] = true
+				conditions = append(conditions, c)
+			}
+		}
+	}
+	var combined []Match
+	windows := map[[2]int]bool{}
+	for _, m := range matches {
+		if len(m.Buckets) == 0 {
+			continue
+		}
+		window := [2]int{m.Offset, len(m.Buckets)}
+		if windows[window] {
+			continue
+		}
+		windows[window] = true
+		m.Conditions = conditions
+		combined = append(combined, m)
+	}
+	if len(combined) == 0 {
+		m := matches[0]
+		m.Conditions = conditions
+		combined = append(combined, m)
+	}
+	return combined
+}
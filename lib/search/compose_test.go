@@ -0,0 +1,201 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+// fired builds the expected match without buckets on side fired by conditions.
+func fired(side Side, conditions ...string) Match {
+	return Match{Time: snapshotTime, Price: 100.012, Instrument: oanda.InstrumentUSDJPY, Side: side, Conditions: conditions}
+}
+
+var (
+	stopOrder      = StopOrder{LowerLimits: []float64{0.5}}
+	limitOrder     = LimitOrder{LowerLimits: []float64{0.5}}
+	losingPosition = LosingPosition{LowerLimits: []float64{0.5}}
+)
+
+func TestAnd_Match(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "both fire on the same side",
+			condition:    And{Conditions: []Condition{stopOrder, losingPosition}},
+			orderBook:    newBook(pct{i: 2, long: 0.6}),
+			positionBook: newBook(pct{i: 4, short: 0.7}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{
+					match(o, p, Above, 2, 1, "stop-order", "losing-position"),
+					match(o, p, Above, 4, 1, "stop-order", "losing-position"),
+				}
+			},
+		},
+		{
+			name:         "fire on the different sides",
+			condition:    And{Conditions: []Condition{stopOrder, losingPosition}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}),
+			positionBook: newBook(pct{i: 4, short: 0.7}),
+			expected:     none,
+		},
+		{
+			name:         "one fires on both sides at different offsets",
+			condition:    And{Conditions: []Condition{stopOrder, limitOrder}},
+			orderBook:    newBook(pct{below: true, i: 1, short: 0.6}, pct{i: 3, long: 1, short: 1}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 3, 1, "stop-order", "limit-order")}
+			},
+		},
+		{
+			name:         "only one fires",
+			condition:    And{Conditions: []Condition{stopOrder, limitOrder}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+		{
+			name:         "with not",
+			condition:    And{Conditions: []Condition{stopOrder, Not{Condition: limitOrder}}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}, pct{i: 3, short: 0.9}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 2, 1, "stop-order", "!limit-order")}
+			},
+		},
+		{
+			name:         "blocked by not",
+			condition:    And{Conditions: []Condition{stopOrder, Not{Condition: limitOrder}}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}, pct{below: true, i: 4, long: 0.9}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+		{
+			name:         "no conditions",
+			condition:    And{},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+	})
+}
+
+func TestOr_Match(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "one fires",
+			condition:    Or{Conditions: []Condition{stopOrder, limitOrder}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 2, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "both fire on the same side",
+			condition:    Or{Conditions: []Condition{stopOrder, limitOrder}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}, pct{below: true, i: 5, long: 0.6}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{
+					match(o, p, Below, 2, 1, "stop-order", "limit-order"),
+					match(o, p, Below, 5, 1, "stop-order", "limit-order"),
+				}
+			},
+		},
+		{
+			name:         "fire on the different sides",
+			condition:    Or{Conditions: []Condition{stopOrder, losingPosition}},
+			orderBook:    newBook(pct{i: 2, long: 0.6}),
+			positionBook: newBook(pct{below: true, i: 1, long: 0.6}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 1, 1, "losing-position")}
+			},
+		},
+		{
+			name:         "fire on the different sides reporting all",
+			condition:    Or{Conditions: []Condition{stopOrder, losingPosition}},
+			window:       Window{Buckets: 20, Report: ReportAll},
+			orderBook:    newBook(pct{i: 2, long: 0.6}),
+			positionBook: newBook(pct{below: true, i: 1, long: 0.6}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{
+					match(o, p, Below, 1, 1, "losing-position"),
+					match(o, p, Above, 2, 1, "stop-order"),
+				}
+			},
+		},
+		{
+			name:         "nearer side of the composed matches",
+			condition:    Or{Conditions: []Condition{stopOrder, limitOrder}},
+			orderBook:    newBook(pct{below: true, i: 1, short: 0.6}, pct{i: 3, long: 1, short: 1}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 1, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "none fires",
+			condition:    Or{Conditions: []Condition{stopOrder, limitOrder}},
+			orderBook:    newBook(),
+			positionBook: newBook(),
+			expected:     none,
+		},
+	})
+}
+
+func TestNot_Match(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "fires on the other side",
+			condition:    Not{Condition: stopOrder},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}),
+			positionBook: newBook(),
+			expected: func(_, _ *oanda.Book) []Match {
+				return []Match{fired(Above, "!stop-order")}
+			},
+		},
+		{
+			name:         "fires on both sides",
+			condition:    Not{Condition: stopOrder},
+			orderBook:    newBook(),
+			positionBook: newBook(),
+			expected: func(_, _ *oanda.Book) []Match {
+				return []Match{fired(Below, "!stop-order"), fired(Above, "!stop-order")}
+			},
+		},
+		{
+			name:         "condition fires on both sides at different offsets",
+			condition:    Not{Condition: stopOrder},
+			orderBook:    newBook(pct{below: true, i: 1, short: 0.6}, pct{i: 3, long: 1, short: 1}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+		{
+			name:         "double negation",
+			condition:    Not{Condition: Not{Condition: stopOrder}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}),
+			positionBook: newBook(),
+			expected: func(_, _ *oanda.Book) []Match {
+				return []Match{fired(Below, "!!stop-order")}
+			},
+		},
+	})
+}
+
+func TestCondition_Name(t *testing.T) {
+	tests := []struct {
+		condition Condition
+		expected  string
+	}{
+		{stopOrder, "stop-order"},
+		{And{Conditions: []Condition{stopOrder, limitOrder}}, "(stop-order && limit-order)"},
+		{Or{Conditions: []Condition{stopOrder, Not{Condition: And{Conditions: []Condition{limitOrder, losingPosition}}}}},
+			"(stop-order || !(limit-order && losing-position))"},
+	}
+	for i, tt := range tests {
+		if actual := tt.condition.Name(); actual != tt.expected {
+			t.Errorf("#%d Name() = %v, expected: %v", i, actual, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package search
+
+// StopOrder finds clusters of stop orders, i.e. sell orders below the price and buy orders
+// above the price. LowerLimits are the lower limits of consecutive buckets from the nearest
+// to the price.
+//
+// If Net is true, the net amounts in favor of the side searched are compared instead, i.e. short
+// minus long below the price and long minus short above the price for stop orders, so that a
+// positive limit finds buckets dominated by the side searched. A negative limit is the upper limit
+// of the net amount then, which finds buckets dominated by the other side. The same applies to
+// the other searches with their sides.
+type StopOrder struct {
+	LowerLimits []float64
+	Net         bool
+}
+
+func (c StopOrder) Name() string { return "stop-order" }
+
+func (c StopOrder) Match(s *Snapshot) []Match { return s.nearerSide(c.matchSides(s)) }
+
+func (c StopOrder) matchSides(s *Snapshot) []Match {
+	if c.Net {
+		return matchWindow(s, c.Name(), c.LowerLimits, shortNetOrder, netOrder)
+	}
+	return matchWindow(s, c.Name(), c.LowerLimits, shortOrder, longOrder)
+}
+
+// LimitOrder finds clusters of limit orders, i.e. buy orders below the price and sell orders
+// above the price.
+type LimitOrder struct {
+	LowerLimits []float64
+	Net         bool
+}
+
+func (c LimitOrder) Name() string { return "limit-order" }
+
+func (c LimitOrder) Match(s *Snapshot) []Match { return s.nearerSide(c.matchSides(s)) }
+
+func (c LimitOrder) matchSides(s *Snapshot) []Match {
+	if c.Net {
+		return matchWindow(s, c.Name(), c.LowerLimits, netOrder, shortNetOrder)
+	}
+	return matchWindow(s, c.Name(), c.LowerLimits, longOrder, shortOrder)
+}
+
+// LosingPosition finds clusters of losing positions in the position book, i.e. long positions
+// below the price and short positions above the price.
+type LosingPosition struct {
+	LowerLimits []float64
+	Net         bool
+}
+
+func (c LosingPosition) Name() string { return "losing-position" }
+
+func (c LosingPosition) Match(s *Snapshot) []Match { return s.nearerSide(c.matchSides(s)) }
+
+func (c LosingPosition) matchSides(s *Snapshot) []Match {
+	if c.Net {
+		return matchWindow(s, c.Name(), c.LowerLimits, netPosition, shortNetPosition)
+	}
+	return matchWindow(s, c.Name(), c.LowerLimits, longPosition, shortPosition)
+}
+
+// ProfitingPosition finds clusters of profiting positions in the position book, i.e. short
+// positions below the price and long positions above the price.
+type ProfitingPosition struct {
+	LowerLimits []float64
+	Net         bool
+}
+
+func (c ProfitingPosition) Name() string { return "profiting-position" }
+
+func (c ProfitingPosition) Match(s *Snapshot) []Match { return s.nearerSide(c.matchSides(s)) }
+
+func (c ProfitingPosition) matchSides(s *Snapshot) []Match {
+	if c.Net {
+		return matchWindow(s, c.Name(), c.LowerLimits, shortNetPosition, netPosition)
+	}
+	return matchWindow(s, c.Name(), c.LowerLimits, shortPosition, longPosition)
+}
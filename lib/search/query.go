@@ -0,0 +1,511 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query is a condition written in the query language. It is evaluated on each side of a snapshot.
+//
+//	query      = or
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" query ")" | comparison
+//	comparison = "side" ( "=" | "==" | "!=" ) ( "above" | "below" )
+//	           | operand ( "<" | "<=" | ">" | ">=" | "==" | "!=" ) operand
+//	operand    = number | ( "order" | "position" ) "." ( "short" | "long" | "net" ) range | "distance" range
+//	range      = "[" index [ ".." index ] "]"
+//
+// order.short[i] is the short percentage of the order book in i-th bucket from the price on the side,
+// the nearest bucket is 0, and order.net[i] is the net amount, long minus short. distance[i] is the
+// distance from the price to the range of i-th bucket in pips, which is 0 if it covers the price.
+// A range [i..j] includes both ends and a comparison with it holds if it holds for every bucket in the range.
+// Ranges compared with each other must have the same length and are compared bucket by bucket.
+// A comparison with a bucket out of the window of the search does not hold.
+//
+// ex: side=above && order.short[0..3] >= 0.8 && position.long[0] < 0.3 && distance[0] <= 10
+type Query struct {
+	src  string
+	root queryNode
+	// buckets referred by the query, from is -1 if no bucket is referred
+	from, to int
+}
+
+// QueryError is an error of parsing a query at Column, which starts from 1.
+type QueryError struct {
+	Query  string
+	Column int
+	Msg    string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+// Caret returns the query with a caret pointing at the column of the error under it.
+func (e *QueryError) Caret() string {
+	return e.Query + "\n" + strings.Repeat(" ", e.Column-1) + "^"
+}
+
+// ParseQuery parses src in the query language.
+func ParseQuery(src string) (*Query, error) {
+	tokens, err := lexQuery(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{src: src, tokens: tokens, from: -1, to: -1}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %s, expected && or ||", t)
+	}
+	return &Query{src: src, root: root, from: p.from, to: p.to}, nil
+}
+
+func (q *Query) Name() string { return q.src }
+
+// Size returns the number of buckets in a match of the query.
+// The buckets are from the nearest one referred by the query to the farthest one.
+func (q *Query) Size() int {
+	if q.from < 0 {
+		return 0
+	}
+	return q.to - q.from + 1
+}
+
+func (q *Query) Match(s *Snapshot) []Match {
+	var matches []Match
+	for _, side := range sides {
+		if !q.root.eval(s, side) {
+			continue
+		}
+		var buckets []Bucket
+		for i := q.from; i >= 0 && i <= q.to; i++ {
+			if b, ok := s.bucket(side, i); ok {
+				buckets = append(buckets, b)
+			}
+		}
+		// a match without buckets has no offset like the matches of Not
+		offset := 0
+		if len(buckets) > 0 {
+			offset = q.from
+		}
+		matches = append(matches, s.match(side, offset, []string{q.Name()}, buckets))
+	}
+	return matches
+}
+
+type queryNode interface {
+	eval(s *Snapshot, side Side) bool
+}
+
+type andNode struct{ l, r queryNode }
+
+func (n andNode) eval(s *Snapshot, side Side) bool { return n.l.eval(s, side) && n.r.eval(s, side) }
+
+type orNode struct{ l, r queryNode }
+
+func (n orNode) eval(s *Snapshot, side Side) bool { return n.l.eval(s, side) || n.r.eval(s, side) }
+
+type notNode struct{ n queryNode }
+
+func (n notNode) eval(s *Snapshot, side Side) bool { return !n.n.eval(s, side) }
+
+type sideNode struct {
+	side  Side
+	equal bool
+}
+
+func (n sideNode) eval(_ *Snapshot, side Side) bool { return (side == n.side) == n.equal }
+
+type compareNode struct {
+	op   string
+	l, r operand
+}
+
+func (n compareNode) eval(s *Snapshot, side Side) bool {
+	l, r := n.l.values(s, side), n.r.values(s, side)
+	if l == nil || r == nil {
+		return false
+	}
+	size := len(l)
+	if size < len(r) {
+		size = len(r)
+	}
+	for i := 0; i < size; i++ {
+		// a number is compared with every bucket
+		lv, rv := l[0], r[0]
+		if len(l) > 1 {
+			lv = l[i]
+		}
+		if len(r) > 1 {
+			rv = r[i]
+		}
+		if !compare(n.op, lv, rv) {
+			return false
+		}
+	}
+	return true
+}
+
+func compare(op string, l, r float64) bool {
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	}
+	return false
+}
+
+// operand is a number, the percentages of the buckets from..to if percent is not nil, or
+// the distances of the buckets from..to if distance is true.
+type operand struct {
+	number   float64
+	percent  percent
+	distance bool
+	from, to int
+}
+
+// values returns nil if any of the buckets is out of the window.
+func (o operand) values(s *Snapshot, side Side) []float64 {
+	if o.isNumber() {
+		return []float64{o.number}
+	}
+	var values []float64
+	for i := o.from; i <= o.to; i++ {
+		b, ok := s.bucket(side, i)
+		if !ok {
+			return nil
+		}
+		if o.distance {
+			values = append(values, float64(s.distance(side, i)))
+		} else {
+			values = append(values, o.percent(b))
+		}
+	}
+	return values
+}
+
+func (o operand) isNumber() bool { return o.percent == nil && !o.distance }
+
+func (o operand) size() int {
+	if o.isNumber() {
+		return 1
+	}
+	return o.to - o.from + 1
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenOp
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	column int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of query"
+	}
+	return strconv.Quote(t.text)
+}
+
+// queryOps are the operators of the query language. Longer ones are listed first.
+var queryOps = []string{"&&", "||", "..", "<=", ">=", "==", "!=", "<", ">", "=", "!", "(", ")", "[", "]", "."}
+
+func lexQuery(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case isLetter(c):
+			j := i
+			for j < len(src) && isLetter(src[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, src[i:j], i + 1})
+			i = j
+		case isDigit(c) || c == '-' && i+1 < len(src) && isDigit(src[i+1]):
+			j := i + 1
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.' && j+1 < len(src) && isDigit(src[j+1])) {
+				j++
+			}
+			tokens = append(tokens, token{tokenNumber, src[i:j], i + 1})
+			i = j
+		default:
+			op := ""
+			for _, o := range queryOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &QueryError{Query: src, Column: i + 1, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			tokens = append(tokens, token{tokenOp, op, i + 1})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(src) + 1}), nil
+}
+
+func isLetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' }
+func isDigit(c byte) bool  { return '0' <= c && c <= '9' }
+
+type queryParser struct {
+	src    string
+	tokens []token
+	pos    int
+	// buckets referred by the query
+	from, to int
+}
+
+func (p *queryParser) peek() token { return p.tokens[p.pos] }
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) errorf(t token, format string, args ...interface{}) error {
+	return &QueryError{Query: p.src, Column: t.column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// expect consumes the operator op.
+func (p *queryParser) expect(op string) error {
+	if t := p.next(); t.kind != tokenOp || t.text != op {
+		return p.errorf(t, "unexpected %s, expected %q", t, op)
+	}
+	return nil
+}
+
+func (p *queryParser) isOp(t token, ops ...string) bool {
+	if t.kind != tokenOp {
+		return false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(p.peek(), "||") {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(p.peek(), "&&") {
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	t := p.peek()
+	if p.isOp(t, "!") {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	if p.isOp(t, "(") {
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	if t.kind == tokenIdent && t.text == "side" {
+		return p.parseSide()
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseSide() (queryNode, error) {
+	p.next()
+	t := p.next()
+	if !p.isOp(t, "=", "==", "!=") {
+		return nil, p.errorf(t, "unexpected %s, expected = or !=", t)
+	}
+	n := sideNode{equal: t.text != "!="}
+	switch v := p.next(); {
+	case v.kind == tokenIdent && v.text == "above":
+		n.side = Above
+	case v.kind == tokenIdent && v.text == "below":
+		n.side = Below
+	default:
+		return nil, p.errorf(v, "unexpected %s, expected above or below", v)
+	}
+	return n, nil
+}
+
+func (p *queryParser) parseComparison() (queryNode, error) {
+	start := p.peek()
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.next()
+	if !p.isOp(t, "<", "<=", ">", ">=", "==", "!=", "=") {
+		return nil, p.errorf(t, "unexpected %s, expected a comparison operator", t)
+	}
+	r, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if l.isNumber() && r.isNumber() {
+		return nil, p.errorf(start, "comparison of numbers, expected a bucket such as order.short[0]")
+	}
+	if l.size() > 1 && r.size() > 1 && l.size() != r.size() {
+		return nil, p.errorf(t, "ranges of %d and %d buckets are compared", l.size(), r.size())
+	}
+	op := t.text
+	if op == "=" {
+		op = "=="
+	}
+	return compareNode{op: op, l: l, r: r}, nil
+}
+
+func (p *queryParser) parseOperand() (operand, error) {
+	t := p.next()
+	if t.kind == tokenNumber {
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return operand{}, p.errorf(t, "invalid number %s", t)
+		}
+		return operand{number: v}, nil
+	}
+	if t.kind == tokenIdent && t.text == "distance" {
+		o := operand{distance: true}
+		if err := p.parseRange(&o); err != nil {
+			return operand{}, err
+		}
+		return o, nil
+	}
+	if t.kind != tokenIdent || t.text != "order" && t.text != "position" {
+		return operand{}, p.errorf(t, "unexpected %s, expected a number, order, position or distance", t)
+	}
+	if err := p.expect("."); err != nil {
+		return operand{}, err
+	}
+	f := p.next()
+	var o operand
+	switch {
+	case f.kind == tokenIdent && f.text == "short" && t.text == "order":
+		o.percent = shortOrder
+	case f.kind == tokenIdent && f.text == "long" && t.text == "order":
+		o.percent = longOrder
+	case f.kind == tokenIdent && f.text == "short" && t.text == "position":
+		o.percent = shortPosition
+	case f.kind == tokenIdent && f.text == "long" && t.text == "position":
+		o.percent = longPosition
+	case f.kind == tokenIdent && f.text == "net" && t.text == "order":
+		o.percent = netOrder
+	case f.kind == tokenIdent && f.text == "net" && t.text == "position":
+		o.percent = netPosition
+	default:
+		return operand{}, p.errorf(f, "unexpected %s, expected short, long or net", f)
+	}
+	if err := p.parseRange(&o); err != nil {
+		return operand{}, err
+	}
+	return o, nil
+}
+
+// parseRange parses the range of buckets of o and records the buckets referred by the query.
+func (p *queryParser) parseRange(o *operand) error {
+	if err := p.expect("["); err != nil {
+		return err
+	}
+	from, err := p.parseIndex()
+	if err != nil {
+		return err
+	}
+	o.from, o.to = from, from
+	if p.isOp(p.peek(), "..") {
+		p.next()
+		to := p.peek()
+		if o.to, err = p.parseIndex(); err != nil {
+			return err
+		}
+		if o.to < o.from {
+			return p.errorf(to, "range [%d..%d] is reversed", o.from, o.to)
+		}
+	}
+	if err := p.expect("]"); err != nil {
+		return err
+	}
+	if p.from < 0 || o.from < p.from {
+		p.from = o.from
+	}
+	if o.to > p.to {
+		p.to = o.to
+	}
+	return nil
+}
+
+func (p *queryParser) parseIndex() (int, error) {
+	t := p.next()
+	if t.kind != tokenNumber {
+		return 0, p.errorf(t, "unexpected %s, expected an index", t)
+	}
+	i, err := strconv.Atoi(t.text)
+	if err != nil || i < 0 {
+		return 0, p.errorf(t, "invalid index %s, expected 0 or more", t.text)
+	}
+	return i, nil
+}
@@ -0,0 +1,159 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+func mustParseQuery(src string) *Query {
+	q, err := ParseQuery(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func TestQuery_Match(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "range on a side",
+			condition:    mustParseQuery("side=above && order.short[0..3] >= 0.8 && position.long[0] < 0.3"),
+			orderBook:    newBook(pct{i: 0, short: 0.8}, pct{i: 1, short: 0.9}, pct{i: 2, short: 1.5}, pct{i: 3, short: 0.8}),
+			positionBook: newBook(pct{i: 0, long: 0.2}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 0, 4, "side=above && order.short[0..3] >= 0.8 && position.long[0] < 0.3")}
+			},
+		},
+		{
+			name:         "range with a bucket below the limit",
+			condition:    mustParseQuery("order.short[0..3] >= 0.8"),
+			orderBook:    newBook(pct{i: 0, short: 0.8}, pct{i: 1, short: 0.9}, pct{i: 2, short: 0.7}, pct{i: 3, short: 0.8}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+		{
+			name:         "both sides",
+			condition:    mustParseQuery("order.long[1] > 0.5"),
+			orderBook:    newBook(pct{below: true, i: 1, long: 0.6}, pct{i: 1, long: 0.7}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{
+					match(o, p, Below, 1, 1, "order.long[1] > 0.5"),
+					match(o, p, Above, 1, 1, "order.long[1] > 0.5"),
+				}
+			},
+		},
+		{
+			name:         "order compared with position bucket by bucket",
+			condition:    mustParseQuery("order.short[2..3] > position.short[2..3]"),
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}, pct{below: true, i: 3, short: 0.6}, pct{i: 2, short: 0.6}, pct{i: 3, short: 0.6}),
+			positionBook: newBook(pct{below: true, i: 3, short: 0.5}, pct{i: 3, short: 0.7}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 2, 2, "order.short[2..3] > position.short[2..3]")}
+			},
+		},
+		{
+			name:         "or and not with parentheses",
+			condition:    mustParseQuery("!(side == below) && (order.long[0] >= 1 || position.short[5] >= 1)"),
+			orderBook:    newBook(pct{below: true, i: 0, long: 1}),
+			positionBook: newBook(pct{i: 5, short: 1}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 0, 6, "!(side == below) && (order.long[0] >= 1 || position.short[5] >= 1)")}
+			},
+		},
+		{
+			name:         "distance from the price",
+			condition:    mustParseQuery("distance[1] < 5"),
+			orderBook:    newBook(),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				// 1.2 pips below and 8.8 pips above
+				return []Match{match(o, p, Below, 1, 1, "distance[1] < 5")}
+			},
+		},
+		{
+			name:         "range within a distance",
+			condition:    mustParseQuery("order.long[0..1] >= 0.5 && distance[0..1] <= 5"),
+			orderBook:    newBook(pct{below: true, i: 0, long: 0.5}, pct{below: true, i: 1, long: 0.5}, pct{i: 0, long: 0.5}, pct{i: 1, long: 0.5}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 0, 2, "order.long[0..1] >= 0.5 && distance[0..1] <= 5")}
+			},
+		},
+		{
+			name:         "side only",
+			condition:    mustParseQuery("side != above"),
+			orderBook:    newBook(),
+			positionBook: newBook(),
+			expected: func(_, _ *oanda.Book) []Match {
+				return []Match{fired(Below, "side != above")}
+			},
+		},
+	})
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		src    string
+		column int
+		size   int
+	}{
+		{"order.short[0] >= 0.8", 0, 1},
+		{"side=above && order.short[0..3] >= 0.8 && position.long[0] < 0.3", 0, 4},
+		{"position.long[2..4] < order.long[5..7] || 0.1 <= order.short[19]", 0, 18},
+		{"side=above", 0, 0},
+		{"order.short[0] >= -0.1", 0, 1},
+		{"distance[0] < 5", 0, 1},
+		{"distance[0..2] <= 10 && order.short[4] >= 0.5", 0, 5},
+		{"", 1, 0},
+		{"distance <= 10", 10, 0},
+		{"distance.short[0] <= 10", 9, 0},
+		{"order.short[0] >=", 18, 0},
+		{"order.middle[0] >= 0.8", 7, 0},
+		{"book.short[0] >= 0.8", 1, 0},
+		{"order.short[-1] >= 0.8", 13, 0},
+		{"order.short[30] >= 0.8", 0, 1},
+		{"order.short[3..1] >= 0.8", 16, 0},
+		{"order.short[0.5] >= 0.8", 13, 0},
+		{"order.short[0 >= 0.8", 15, 0},
+		{"order.short[0] ~ 0.8", 16, 0},
+		{"order.short[0] => 0.8", 17, 0},
+		{"side=left", 6, 0},
+		{"side > above", 6, 0},
+		{"0.5 < 0.8", 1, 0},
+		{"order.short[0..1] < position.long[0..2]", 19, 0},
+		{"(order.short[0] >= 0.8", 23, 0},
+		{"order.short[0] >= 0.8)", 22, 0},
+		{"order.short[0] >= 0.8 & side=above", 23, 0},
+	}
+	for i, tt := range tests {
+		q, err := ParseQuery(tt.src)
+		if tt.column == 0 {
+			if err != nil {
+				t.Errorf("#%d ParseQuery(%q) error = %v", i, tt.src, err)
+			} else if q.Size() != tt.size {
+				t.Errorf("#%d Size() = %v, expected: %v", i, q.Size(), tt.size)
+			}
+			continue
+		}
+		qe, ok := err.(*QueryError)
+		if !ok {
+			t.Errorf("#%d ParseQuery(%q) error = %v, expected QueryError", i, tt.src, err)
+			continue
+		}
+		if qe.Column != tt.column {
+			t.Errorf("#%d ParseQuery(%q) error at column %d (%v), expected: %d", i, tt.src, qe.Column, qe, tt.column)
+		}
+	}
+}
+
+func TestQueryError_Caret(t *testing.T) {
+	_, err := ParseQuery("side=above && order.short[0] >")
+	expected := "" +
+		"side=above && order.short[0] >\n" +
+		"                              ^"
+	if actual := err.(*QueryError).Caret(); actual != expected {
+		t.Errorf("Caret() = \n%s\nexpected:\n%s", actual, expected)
+	}
+}
@@ -0,0 +1,375 @@
+// Package search finds clusters of orders and positions around the price in snapshots of
+// OANDA order books and position books.
+package search
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+// Report selects the matching windows reported in a snapshot.
+type Report int
+
+const (
+	// ReportNearest reports the window nearest to the price. Both sides are reported if they
+	// match at the same distance. Composed conditions report the nearer side of the composed matches.
+	ReportNearest Report = iota
+	// ReportAll reports every matching window which does not overlap a nearer one on the side.
+	ReportAll
+	// ReportOverlapping reports every matching window.
+	ReportOverlapping
+)
+
+// ParseReport parses nearest, all or overlapping.
+func ParseReport(s string) (Report, error) {
+	switch s {
+	case "nearest":
+		return ReportNearest, nil
+	case "all":
+		return ReportAll, nil
+	case "overlapping":
+		return ReportOverlapping, nil
+	}
+	return ReportNearest, fmt.Errorf("invalid report: %s (nearest, all or overlapping)", s)
+}
+
+func (r Report) String() string {
+	switch r {
+	case ReportAll:
+		return "all"
+	case ReportOverlapping:
+		return "overlapping"
+	}
+	return "nearest"
+}
+
+// Window limits the buckets searched on each side of the price.
+// A side has fewer buckets if the books are truncated near the price.
+type Window struct {
+	// Buckets is the maximum number of buckets on each side. It is not limited if 0.
+	Buckets int
+	// Pips is the maximum distance from the price to a bucket in pips. It is not limited if 0.
+	Pips oanda.Pips
+	// Report selects the matching windows of consecutive buckets reported by the searches.
+	Report Report
+}
+
+// DefaultWindow is the window of 20 buckets on each side.
+var DefaultWindow = Window{Buckets: 20}
+
+func (w Window) String() string {
+	if w.Report != ReportNearest {
+		return fmt.Sprintf("buckets=%d pips=%v report=%s", w.Buckets, w.Pips, w.Report)
+	}
+	return fmt.Sprintf("buckets=%d pips=%v", w.Buckets, w.Pips)
+}
+
+// Bucket is a bucket of a match with the percentages of both books.
+type Bucket struct {
+	Price         oanda.Price
+	ShortOrder    float64
+	LongOrder     float64
+	ShortPosition float64
+	LongPosition  float64
+}
+
+// Side is the side of the price where a match is found.
+type Side int
+
+const (
+	Below Side = iota
+	Above
+)
+
+// sides are the sides in the order matches are reported.
+var sides = []Side{Below, Above}
+
+func (s Side) String() string {
+	if s == Above {
+		return "above"
+	}
+	return "below"
+}
+
+// Match is a window of consecutive buckets satisfying a condition in a snapshot.
+// Buckets are ordered from the nearest to the price.
+type Match struct {
+	Time       time.Time
+	Price      oanda.Price
+	Instrument oanda.Instrument
+	Side       Side
+	// Offset is the index of the nearest bucket of the match from the price, 0 is the nearest on the side.
+	Offset int
+	// Distance is the distance from the price to the range of the nearest bucket of the match in pips.
+	Distance oanda.Pips
+	// Conditions are the names of the conditions which fired on the side of the snapshot.
+	Conditions []string
+	// Kind is the kind of the condition which found the match such as stop-order. It is set by Search.
+	Kind string
+	// Search is the name of the search which found the match. It is set by the caller.
+	Search  string
+	Buckets []Bucket
+}
+
+// DistancePips returns the distance from the price to the range of the nearest bucket of the match
+// in pips. ok is false if the match has no buckets.
+func (m Match) DistancePips() (pips oanda.Pips, ok bool) {
+	if len(m.Buckets) == 0 {
+		return 0, false
+	}
+	return m.Distance, true
+}
+
+// Condition finds matches in a snapshot of the order book and the position book.
+type Condition interface {
+	Match(s *Snapshot) []Match
+	// Name returns the name recorded in matches.
+	Name() string
+}
+
+// Search finds matches of every condition in the buckets of the snapshot within window.
+// The matches are ordered by condition and record the kind of the condition.
+// It fails if the order book has no buckets.
+func Search(orderBook, positionBook *oanda.Book, window Window, conditions ...Condition) ([]Match, error) {
+	s, err := NewSnapshot(orderBook, positionBook, window)
+	if err != nil {
+		return nil, err
+	}
+	var matches []Match
+	for _, c := range conditions {
+		kind := Kind(c)
+		for _, m := range c.Match(s) {
+			m.Kind = kind
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// Kind returns the kind of c, which is the name of a search such as stop-order, query for a query,
+// or and, or and not for composed conditions.
+func Kind(c Condition) string {
+	switch c.(type) {
+	case *Query:
+		return "query"
+	case And:
+		return "and"
+	case Or:
+		return "or"
+	case Not:
+		return "not"
+	}
+	return c.Name()
+}
+
+// Snapshot holds the buckets of both books within a window around the price of the order book.
+type Snapshot struct {
+	time       time.Time
+	price      oanda.Price
+	instrument oanda.Instrument
+	report     Report
+	// buckets below the price ordered from the highest and buckets above the price from the lowest
+	below, above []Bucket
+	// distances from the price to the ranges of the buckets in pips
+	belowDistances, aboveDistances []oanda.Pips
+}
+
+// NewSnapshot merges the buckets of the books within window. The buckets are the ones of the
+// order book and the position book has zero percentages in a range which none of its buckets covers.
+func NewSnapshot(orderBook, positionBook *oanda.Book, window Window) (*Snapshot, error) {
+	if len(orderBook.Buckets) == 0 {
+		return nil, fmt.Errorf("order book has no buckets")
+	}
+	s := &Snapshot{
+		time:       orderBook.Time,
+		price:      orderBook.Price,
+		instrument: orderBook.Instrument,
+		report:     window.Report,
+	}
+	below, above := orderBook.Vicinity(window.Buckets)
+	positions := positionBook.Index()
+	merge := func(orders []oanda.BookBucket) (buckets []Bucket, distances []oanda.Pips) {
+		for _, o := range orders {
+			d := orderBook.DistanceToPrice(o)
+			if window.Pips > 0 && d > window.Pips {
+				break
+			}
+			p, _ := positions.Covering(orderBook, o)
+			buckets = append(buckets, Bucket{
+				Price:         o.Price,
+				ShortOrder:    o.ShortCountPercent,
+				LongOrder:     o.LongCountPercent,
+				ShortPosition: p.ShortCountPercent,
+				LongPosition:  p.LongCountPercent,
+			})
+			distances = append(distances, d)
+		}
+		return buckets, distances
+	}
+	s.below, s.belowDistances = merge(below)
+	s.above, s.aboveDistances = merge(above)
+	return s, nil
+}
+
+// buckets returns the buckets on side from the nearest to the price.
+func (s *Snapshot) buckets(side Side) []Bucket {
+	if side == Below {
+		return s.below
+	}
+	return s.above
+}
+
+// bucket returns i-th bucket from the price on side. ok is false if it is out of the window.
+func (s *Snapshot) bucket(side Side, i int) (b Bucket, ok bool) {
+	buckets := s.buckets(side)
+	if i < 0 || i >= len(buckets) {
+		return Bucket{}, false
+	}
+	return buckets[i], true
+}
+
+// distance returns the distance from the price to the range of i-th bucket from the price on side in pips.
+func (s *Snapshot) distance(side Side, i int) oanda.Pips {
+	if side == Below {
+		return s.belowDistances[i]
+	}
+	return s.aboveDistances[i]
+}
+
+// match builds a match of buckets from offset on side of the snapshot.
+func (s *Snapshot) match(side Side, offset int, conditions []string, buckets []Bucket) Match {
+	m := Match{
+		Time:       s.time,
+		Price:      s.price,
+		Instrument: s.instrument,
+		Side:       side,
+		Offset:     offset,
+		Conditions: conditions,
+		Buckets:    buckets,
+	}
+	if len(buckets) > 0 {
+		m.Distance = s.distance(side, offset)
+	}
+	return m
+}
+
+// NetOrder returns the net amount of orders in the bucket, long minus short.
+func (b Bucket) NetOrder() float64 { return b.LongOrder - b.ShortOrder }
+
+// NetPosition returns the net amount of positions in the bucket, long minus short.
+func (b Bucket) NetPosition() float64 { return b.LongPosition - b.ShortPosition }
+
+// percent selects the percentage of a bucket compared with lower limits.
+type percent func(b Bucket) float64
+
+func shortOrder(b Bucket) float64    { return b.ShortOrder }
+func longOrder(b Bucket) float64     { return b.LongOrder }
+func shortPosition(b Bucket) float64 { return b.ShortPosition }
+func longPosition(b Bucket) float64  { return b.LongPosition }
+func netOrder(b Bucket) float64      { return b.NetOrder() }
+func netPosition(b Bucket) float64   { return b.NetPosition() }
+
+// shortNetOrder and shortNetPosition are the net amounts in favor of short, short minus long.
+func shortNetOrder(b Bucket) float64    { return -b.NetOrder() }
+func shortNetPosition(b Bucket) float64 { return -b.NetPosition() }
+
+// reached reports whether v reaches limit. A negative limit is an upper limit of net amounts
+// so that v reaches it if v is limit or less.
+func reached(v, limit float64) bool {
+	if limit < 0 {
+		return v <= limit
+	}
+	return v >= limit
+}
+
+// matchWindow finds the windows whose i-th bucket has the percentage which reaches lowerLimits[i].
+// The windows are reported on both sides according to the report of the snapshot, ordered by side
+// and from the nearest to the price. ReportNearest reports the nearest window on each side, and
+// nearerSide keeps the nearer side of them. Windows below the price are compared with belowPercent
+// and windows above the price with abovePercent. The matches are recorded as fired by the
+// condition of name.
+func matchWindow(s *Snapshot, name string, lowerLimits []float64, belowPercent, abovePercent percent) []Match {
+	if len(lowerLimits) == 0 {
+		return nil
+	}
+	var matches []Match
+	for _, side := range sides {
+		pct := abovePercent
+		if side == Below {
+			pct = belowPercent
+		}
+		buckets := s.buckets(side)
+		for i := 0; i+len(lowerLimits) <= len(buckets); i++ {
+			window := buckets[i : i+len(lowerLimits)]
+			ok := true
+			for j, b := range window {
+				if !reached(pct(b), lowerLimits[j]) {
+					ok = false
+					break
+				}
+			}
+			if !ok {
+				continue
+			}
+			matches = append(matches, s.match(side, i, []string{name}, window))
+			if s.report == ReportNearest {
+				break
+			}
+			if s.report == ReportAll {
+				i += len(lowerLimits) - 1
+			}
+		}
+	}
+	return matches
+}
+
+// sideMatcher is implemented by the conditions of the package. matchSides finds the matches on
+// both sides of the snapshot before ReportNearest keeps the nearer side, so that And, Or and Not
+// compose the conditions side by side and the nearer side is kept once after composing them.
+type sideMatcher interface {
+	matchSides(s *Snapshot) []Match
+}
+
+// matchSides returns the matches of c on both sides of the snapshot.
+func matchSides(c Condition, s *Snapshot) []Match {
+	if m, ok := c.(sideMatcher); ok {
+		return m.matchSides(s)
+	}
+	return c.Match(s)
+}
+
+// nearerSide keeps only the matches on the side nearer to the price if the report of the snapshot
+// is ReportNearest. A side is as near as its nearest match, and both sides are kept if they are at
+// the same offset. Matches without buckets have no offset, so that a side of only them is kept.
+func (s *Snapshot) nearerSide(matches []Match) []Match {
+	if s.report != ReportNearest {
+		return matches
+	}
+	offsets := map[Side]int{}
+	for _, m := range matches {
+		if len(m.Buckets) == 0 {
+			continue
+		}
+		if o, ok := offsets[m.Side]; !ok || m.Offset < o {
+			offsets[m.Side] = m.Offset
+		}
+	}
+	below, okBelow := offsets[Below]
+	above, okAbove := offsets[Above]
+	if !okBelow || !okAbove || below == above {
+		return matches
+	}
+	nearer := Below
+	if above < below {
+		nearer = Above
+	}
+	var kept []Match
+	for _, m := range matches {
+		if m.Side == nearer {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
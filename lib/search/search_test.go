@@ -0,0 +1,628 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+var snapshotTime = time.Date(2020, 10, 1, 0, 20, 0, 0, time.UTC)
+
+// pct sets percentages of i-th bucket from the price on a side.
+type pct struct {
+	below bool
+	i     int
+	long  float64
+	short float64
+}
+
+// bucketPrice returns the price of i-th bucket from the price 100.012 on a side.
+func bucketPrice(below bool, i int) oanda.Price {
+	if below {
+		return oanda.Price(100 - float64(i)*0.05).Round(oanda.InstrumentUSDJPY)
+	}
+	return oanda.Price(100 + float64(i+1)*0.05).Round(oanda.InstrumentUSDJPY)
+}
+
+// newBook builds a USD_JPY book at price 100.012 with 25 buckets of 0.05 on each side.
+func newBook(pcts ...pct) *oanda.Book {
+	b := &oanda.Book{
+		Instrument: oanda.InstrumentUSDJPY,
+		Time:       snapshotTime,
+		Price:      100.012,
+	}
+	for i := 24; i >= 0; i-- {
+		b.Buckets = append(b.Buckets, oanda.BookBucket{Price: bucketPrice(true, i)})
+	}
+	for i := 0; i < 25; i++ {
+		b.Buckets = append(b.Buckets, oanda.BookBucket{Price: bucketPrice(false, i)})
+	}
+	for _, p := range pcts {
+		for i := range b.Buckets {
+			if b.Buckets[i].Price == bucketPrice(p.below, p.i) {
+				b.Buckets[i].LongCountPercent = p.long
+				b.Buckets[i].ShortCountPercent = p.short
+			}
+		}
+	}
+	return b
+}
+
+// match builds the expected match of n buckets from i-th bucket on side fired by conditions.
+func match(orderBook, positionBook *oanda.Book, side Side, i, n int, conditions ...string) Match {
+	s, err := NewSnapshot(orderBook, positionBook, Window{})
+	if err != nil {
+		panic(err)
+	}
+	m := Match{Time: snapshotTime, Price: 100.012, Instrument: oanda.InstrumentUSDJPY, Side: side, Offset: i, Conditions: conditions}
+	for j := i; j < i+n; j++ {
+		b, ok := s.bucket(side, j)
+		if !ok {
+			panic("bucket out of the book")
+		}
+		m.Buckets = append(m.Buckets, b)
+	}
+	m.Distance = orderBook.DistancePips(oanda.BookBucket{Price: m.Buckets[0].Price}, m.Price)
+	return m
+}
+
+type conditionTest struct {
+	name         string
+	condition    Condition
+	orderBook    *oanda.Book
+	positionBook *oanda.Book
+	// window is DefaultWindow if it is zero
+	window   Window
+	expected func(orderBook, positionBook *oanda.Book) []Match
+}
+
+func runConditionTests(t *testing.T, tests []conditionTest) {
+	t.Helper()
+	for _, tt := range tests {
+		window := tt.window
+		if window == (Window{}) {
+			window = DefaultWindow
+		}
+		s, err := NewSnapshot(tt.orderBook, tt.positionBook, window)
+		if err != nil {
+			t.Fatalf("%s: NewSnapshot() error = %v", tt.name, err)
+		}
+		actual := tt.condition.Match(s)
+		expected := tt.expected(tt.orderBook, tt.positionBook)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: Match() = %+v, expected: %+v", tt.name, actual, expected)
+		}
+	}
+}
+
+func none(_, _ *oanda.Book) []Match { return nil }
+
+func TestStopOrder_Match(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "sell stops below the price",
+			condition:    StopOrder{LowerLimits: []float64{0.5, 1.0}},
+			orderBook:    newBook(pct{below: true, i: 2, long: 0.1, short: 0.6}, pct{below: true, i: 3, short: 1.2}),
+			positionBook: newBook(pct{below: true, i: 2, long: 0.25, short: 0.35}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 2, 2, "stop-order")}
+			},
+		},
+		{
+			name:         "buy stops above the price",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{i: 4, long: 0.7}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 4, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "both sides at the same distance",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{i: 1, long: 0.7}, pct{below: true, i: 1, short: 0.9}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 1, 1, "stop-order"), match(o, p, Above, 1, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "only the nearest cluster",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{below: true, i: 1, short: 0.6}, pct{below: true, i: 5, short: 0.9}, pct{i: 3, long: 0.9}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 1, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "limits are compared from the nearest bucket",
+			condition:    StopOrder{LowerLimits: []float64{1.0, 0.5}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}, pct{below: true, i: 3, short: 1.2}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+		{
+			name:         "limit orders are ignored",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{below: true, i: 2, long: 0.8}, pct{i: 2, short: 0.8}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+	})
+}
+
+func TestLimitOrder_Match(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "buy limits below the price",
+			condition:    LimitOrder{LowerLimits: []float64{0.5, 0.5}},
+			orderBook:    newBook(pct{below: true, i: 0, long: 0.6}, pct{below: true, i: 1, long: 0.5}),
+			positionBook: newBook(pct{below: true, i: 1, long: 0.3}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 0, 2, "limit-order")}
+			},
+		},
+		{
+			name:         "sell limits above the price",
+			condition:    LimitOrder{LowerLimits: []float64{0.8}},
+			orderBook:    newBook(pct{i: 2, short: 0.8}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 2, 1, "limit-order")}
+			},
+		},
+		{
+			name:         "stop orders are ignored",
+			condition:    LimitOrder{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.8}, pct{i: 2, long: 0.8}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+	})
+}
+
+func TestLosingPosition_Match(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "losing longs below the price",
+			condition:    LosingPosition{LowerLimits: []float64{0.5, 1.0}},
+			orderBook:    newBook(),
+			positionBook: newBook(pct{below: true, i: 3, long: 0.6, short: 0.2}, pct{below: true, i: 4, long: 1.1}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 3, 2, "losing-position")}
+			},
+		},
+		{
+			name:         "losing shorts above the price",
+			condition:    LosingPosition{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{i: 2, long: 0.4}),
+			positionBook: newBook(pct{i: 2, short: 0.9}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 2, 1, "losing-position")}
+			},
+		},
+		{
+			name:         "both sides at the same distance",
+			condition:    LosingPosition{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(),
+			positionBook: newBook(pct{below: true, i: 6, long: 0.5}, pct{i: 6, short: 0.5}, pct{i: 9, short: 0.9}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 6, 1, "losing-position"), match(o, p, Above, 6, 1, "losing-position")}
+			},
+		},
+		{
+			name:         "stop orders are ignored",
+			condition:    LosingPosition{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{below: true, i: 1, short: 0.9}, pct{i: 1, long: 0.9}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+		{
+			name:         "profiting positions are ignored",
+			condition:    LosingPosition{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(),
+			positionBook: newBook(pct{below: true, i: 1, short: 0.9}, pct{i: 1, long: 0.9}),
+			expected:     none,
+		},
+	})
+}
+
+func TestProfitingPosition_Match(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "profiting shorts below the price",
+			condition:    ProfitingPosition{LowerLimits: []float64{0.5, 0.5}},
+			orderBook:    newBook(),
+			positionBook: newBook(pct{below: true, i: 0, short: 0.7}, pct{below: true, i: 1, long: 0.1, short: 0.5}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 0, 2, "profiting-position")}
+			},
+		},
+		{
+			name:         "profiting longs above the price",
+			condition:    ProfitingPosition{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{i: 3, short: 0.3}),
+			positionBook: newBook(pct{i: 3, long: 0.6}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 3, 1, "profiting-position")}
+			},
+		},
+		{
+			name:         "searched beyond the nearest bucket",
+			condition:    ProfitingPosition{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(),
+			positionBook: newBook(pct{below: true, i: 0, short: 0.4}, pct{i: 12, long: 0.8}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 12, 1, "profiting-position")}
+			},
+		},
+		{
+			name:         "limit orders are ignored",
+			condition:    ProfitingPosition{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{below: true, i: 1, long: 0.9}, pct{i: 1, short: 0.9}),
+			positionBook: newBook(),
+			expected:     none,
+		},
+		{
+			name:         "losing positions are ignored",
+			condition:    ProfitingPosition{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(),
+			positionBook: newBook(pct{below: true, i: 1, long: 0.9}, pct{i: 1, short: 0.9}),
+			expected:     none,
+		},
+	})
+}
+
+func TestNet_Match(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "stop orders dominated by long above the price",
+			condition:    StopOrder{LowerLimits: []float64{0.3, 0.3}, Net: true},
+			orderBook:    newBook(pct{i: 1, long: 0.9, short: 0.5}, pct{i: 2, long: 0.6, short: 0.2}, pct{below: true, i: 0, long: 1.0, short: 0.8}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 1, 2, "stop-order")}
+			},
+		},
+		{
+			name:         "stop orders dominated by short below the price",
+			condition:    StopOrder{LowerLimits: []float64{0.3}, Net: true},
+			orderBook:    newBook(pct{below: true, i: 2, long: 0.1, short: 0.4}, pct{i: 1, long: 0.1, short: 0.4}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 2, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "limit orders dominated by short above the price",
+			condition:    LimitOrder{LowerLimits: []float64{0.3}, Net: true},
+			orderBook:    newBook(pct{below: true, i: 2, long: 0.1, short: 0.4}, pct{i: 2, long: 0.2, short: 0.6}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 2, 1, "limit-order")}
+			},
+		},
+		{
+			name:         "limit orders dominated by long below the price",
+			condition:    LimitOrder{LowerLimits: []float64{0.3}, Net: true},
+			orderBook:    newBook(pct{below: true, i: 1, long: 0.7, short: 0.1}, pct{i: 1, long: 0.7, short: 0.1}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 1, 1, "limit-order")}
+			},
+		},
+		{
+			name:         "negative limit finds the other side dominating",
+			condition:    StopOrder{LowerLimits: []float64{-0.3}, Net: true},
+			orderBook:    newBook(pct{below: true, i: 1, long: 0.6, short: 0.2}, pct{i: 0, long: 0.6, short: 0.2}),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 1, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "losing shorts dominating above the price",
+			condition:    LosingPosition{LowerLimits: []float64{0.5}, Net: true},
+			orderBook:    newBook(pct{i: 0, short: 0.9}),
+			positionBook: newBook(pct{i: 4, long: 0.2, short: 0.8}, pct{below: true, i: 2, long: 0.2, short: 0.8}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 4, 1, "losing-position")}
+			},
+		},
+		{
+			name:         "profiting shorts dominating below the price",
+			condition:    ProfitingPosition{LowerLimits: []float64{0.5}, Net: true},
+			orderBook:    newBook(),
+			positionBook: newBook(pct{below: true, i: 3, long: 0.3, short: 0.9}, pct{i: 3, long: 0.9, short: 0.5}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 3, 1, "profiting-position")}
+			},
+		},
+		{
+			name:         "query",
+			condition:    mustParseQuery("order.net[0] <= -0.2 && position.net[0] > 0"),
+			orderBook:    newBook(pct{i: 0, short: 0.5, long: 0.3}, pct{below: true, i: 0, short: 0.5, long: 0.3}),
+			positionBook: newBook(pct{i: 0, long: 0.1}),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 0, 1, "order.net[0] <= -0.2 && position.net[0] > 0")}
+			},
+		},
+	})
+}
+
+func TestSearch(t *testing.T) {
+	orderBook := newBook(pct{below: true, i: 1, short: 0.6, long: 0.7})
+	positionBook := newBook()
+	actual, err := Search(orderBook, positionBook, DefaultWindow,
+		StopOrder{LowerLimits: []float64{0.5}},
+		LimitOrder{LowerLimits: []float64{0.5}},
+		And{Conditions: []Condition{StopOrder{LowerLimits: []float64{0.5}}, LimitOrder{LowerLimits: []float64{0.5}}}},
+	)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	stop := match(orderBook, positionBook, Below, 1, 1, "stop-order")
+	stop.Kind = "stop-order"
+	limit := match(orderBook, positionBook, Below, 1, 1, "limit-order")
+	limit.Kind = "limit-order"
+	and := match(orderBook, positionBook, Below, 1, 1, "stop-order", "limit-order")
+	and.Kind = "and"
+	// the window matched by both conditions is reported once
+	expected := []Match{stop, limit, and}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Search() = %+v, expected: %+v", actual, expected)
+	}
+}
+
+func TestKind(t *testing.T) {
+	tests := []struct {
+		condition Condition
+		expected  string
+	}{
+		{StopOrder{}, "stop-order"},
+		{LimitOrder{}, "limit-order"},
+		{LosingPosition{}, "losing-position"},
+		{ProfitingPosition{}, "profiting-position"},
+		{mustParseQuery("order.short[0] > 0.5"), "query"},
+		{And{}, "and"},
+		{Or{}, "or"},
+		{Not{Condition: StopOrder{}}, "not"},
+	}
+	for i, tt := range tests {
+		if actual := Kind(tt.condition); actual != tt.expected {
+			t.Errorf("#%d Kind() = %v, expected: %v", i, actual, tt.expected)
+		}
+	}
+}
+
+func TestMatch_DistancePips(t *testing.T) {
+	orderBook := newBook()
+	s, err := NewSnapshot(orderBook, newBook(), DefaultWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		side     Side
+		i        int
+		expected oanda.Pips
+	}{
+		// the bucket of 99.95 covers up to 100.00 which is 1.2 pips below the price
+		{Below, 0, 0},
+		{Below, 1, 1.2},
+		{Below, 2, 6.2},
+		{Above, 0, 3.8},
+		{Above, 3, 18.8},
+	}
+	for i, tt := range tests {
+		b, _ := s.bucket(tt.side, tt.i)
+		actual, ok := s.match(tt.side, tt.i, nil, []Bucket{b}).DistancePips()
+		if actual != tt.expected || !ok {
+			t.Errorf("#%d DistancePips() = %v, %v, expected: %v, true", i, actual, ok, tt.expected)
+		}
+	}
+	if _, ok := s.match(Above, 0, nil, nil).DistancePips(); ok {
+		t.Errorf("DistancePips() ok = true, expected false without buckets")
+	}
+}
+
+// truncate drops the buckets of b except for below buckets below the price and above buckets above it.
+func truncate(b *oanda.Book, below, above int) *oanda.Book {
+	lower, higher := b.VicinityOfPrice(b.Price, 0)
+	b.Buckets = nil
+	for i := 0; i < below && i < len(lower); i++ {
+		b.Buckets = append(b.Buckets, lower[i])
+	}
+	for i := 0; i < above && i < len(higher); i++ {
+		b.Buckets = append(b.Buckets, higher[i])
+	}
+	return b
+}
+
+func TestWindow(t *testing.T) {
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "out of the window of buckets",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{below: true, i: 4, short: 0.6}),
+			positionBook: newBook(),
+			window:       Window{Buckets: 4},
+			expected:     none,
+		},
+		{
+			name:         "the last bucket of the window",
+			condition:    StopOrder{LowerLimits: []float64{0.5, 0.5}},
+			orderBook:    newBook(pct{below: true, i: 3, short: 0.6}, pct{below: true, i: 4, short: 0.6}),
+			positionBook: newBook(),
+			window:       Window{Buckets: 5},
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 3, 2, "stop-order")}
+			},
+		},
+		{
+			name:         "beyond the default window",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{i: 22, long: 0.6}),
+			positionBook: newBook(),
+			window:       Window{Buckets: 25},
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 22, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "out of the window of pips",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}),
+			positionBook: newBook(),
+			window:       Window{Pips: 6},
+			expected:     none,
+		},
+		{
+			name:         "within the window of pips",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(pct{below: true, i: 2, short: 0.6}),
+			positionBook: newBook(),
+			window:       Window{Buckets: 20, Pips: 12},
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 2, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "partial window of a truncated book",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    truncate(newBook(pct{i: 2, long: 0.6}), 25, 3),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 2, 1, "stop-order")}
+			},
+		},
+		{
+			name:         "window longer than a truncated side",
+			condition:    StopOrder{LowerLimits: []float64{0.5, 0.5}},
+			orderBook:    truncate(newBook(pct{i: 2, long: 0.6}, pct{below: true, i: 5, short: 0.6}, pct{below: true, i: 6, short: 0.6}), 25, 3),
+			positionBook: newBook(),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 5, 2, "stop-order")}
+			},
+		},
+		{
+			name:         "position book truncated",
+			condition:    LosingPosition{LowerLimits: []float64{0.5}},
+			orderBook:    newBook(),
+			positionBook: truncate(newBook(pct{i: 1, short: 0.6}), 0, 2),
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Above, 1, 1, "losing-position")}
+			},
+		},
+		{
+			name:         "query out of a truncated side",
+			condition:    mustParseQuery("!(order.short[5] >= 0)"),
+			orderBook:    truncate(newBook(), 25, 3),
+			positionBook: newBook(),
+			expected: func(_, _ *oanda.Book) []Match {
+				return []Match{fired(Above, "!(order.short[5] >= 0)")}
+			},
+		},
+	})
+
+	if _, err := Search(&oanda.Book{}, &oanda.Book{}, DefaultWindow, StopOrder{LowerLimits: []float64{0.5}}); err == nil {
+		t.Errorf("Search() error = nil, expected an error of the empty order book")
+	}
+}
+
+func TestReport(t *testing.T) {
+	// stops at 1-2 and 3 below the price and at 5 above it
+	orderBook := func() *oanda.Book {
+		return newBook(
+			pct{below: true, i: 1, short: 0.6}, pct{below: true, i: 2, short: 0.6}, pct{below: true, i: 3, short: 0.6},
+			pct{i: 5, long: 0.6},
+		)
+	}
+	runConditionTests(t, []conditionTest{
+		{
+			name:         "nearest",
+			condition:    StopOrder{LowerLimits: []float64{0.5, 0.5}},
+			orderBook:    orderBook(),
+			positionBook: newBook(),
+			window:       Window{Buckets: 20, Report: ReportNearest},
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 1, 2, "stop-order")}
+			},
+		},
+		{
+			name:         "all",
+			condition:    StopOrder{LowerLimits: []float64{0.5}},
+			orderBook:    orderBook(),
+			positionBook: newBook(),
+			window:       Window{Buckets: 20, Report: ReportAll},
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{
+					match(o, p, Below, 1, 1, "stop-order"),
+					match(o, p, Below, 2, 1, "stop-order"),
+					match(o, p, Below, 3, 1, "stop-order"),
+					match(o, p, Above, 5, 1, "stop-order"),
+				}
+			},
+		},
+		{
+			name:         "all without overlapping",
+			condition:    StopOrder{LowerLimits: []float64{0.5, 0.5}},
+			orderBook:    orderBook(),
+			positionBook: newBook(),
+			window:       Window{Buckets: 20, Report: ReportAll},
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 1, 2, "stop-order")}
+			},
+		},
+		{
+			name:         "overlapping",
+			condition:    StopOrder{LowerLimits: []float64{0.5, 0.5}},
+			orderBook:    orderBook(),
+			positionBook: newBook(),
+			window:       Window{Buckets: 20, Report: ReportOverlapping},
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{match(o, p, Below, 1, 2, "stop-order"), match(o, p, Below, 2, 2, "stop-order")}
+			},
+		},
+		{
+			name:         "all of a combined search",
+			condition:    And{Conditions: []Condition{StopOrder{LowerLimits: []float64{0.5}}, Not{Condition: LimitOrder{LowerLimits: []float64{0.5}}}}},
+			orderBook:    orderBook(),
+			positionBook: newBook(),
+			window:       Window{Buckets: 20, Report: ReportAll},
+			expected: func(o, p *oanda.Book) []Match {
+				return []Match{
+					match(o, p, Below, 1, 1, "stop-order", "!limit-order"),
+					match(o, p, Below, 2, 1, "stop-order", "!limit-order"),
+					match(o, p, Below, 3, 1, "stop-order", "!limit-order"),
+					match(o, p, Above, 5, 1, "stop-order", "!limit-order"),
+				}
+			},
+		},
+	})
+}
+
+func TestParseReport(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected Report
+		err      bool
+	}{
+		{"nearest", ReportNearest, false},
+		{"all", ReportAll, false},
+		{"overlapping", ReportOverlapping, false},
+		{"", ReportNearest, true},
+		{"first", ReportNearest, true},
+	}
+	for i, tt := range tests {
+		actual, err := ParseReport(tt.s)
+		if actual != tt.expected || (err != nil) != tt.err {
+			t.Errorf("#%d ParseReport() = %v, %v, expected: %v, error: %v", i, actual, err, tt.expected, tt.err)
+		}
+		if err == nil && actual.String() != tt.s {
+			t.Errorf("#%d String() = %v, expected: %v", i, actual.String(), tt.s)
+		}
+	}
+}
@@ -1,453 +1,370 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/yuki-inoue-eng/order-book-searcher/lib"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/archive"
 	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/transform"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/search"
 )
 
 var (
-	oandaKey             = flag.String("oanda-key", "", "oanda API key")
 	fileNamePrefix       = flag.String("fname", "ob-search", "")
 	timeLoc              = flag.String("loc", "UTC", "")
 	periodStr            = flag.String("period", "", "specify the aggregation period.")
 	instrumentStr        = flag.String("instrument", "", "specify a instrument.")
 	stopOrderStr         = flag.String("stop-order", "", "")
 	limitOrderStr        = flag.String("limit-order", "", "")
-	losingPositionStr    = flag.String("losingPosition", "", "")
+	losingPositionStr    = flag.String("losing-position", "", "")
 	profitingPositionStr = flag.String("profiting-position", "", "")
+	queryStr             = flag.String("query", "", "search condition in the query language. ex: side=above && order.short[0..3] >= 0.8")
+	combineStr           = flag.String("combine", "", "compose the searches on the same side of a snapshot with and or or.")
+	notStr               = flag.String("not", "", "comma separated searches negated when they are composed with combine.")
 	jp                   = flag.Bool("jp", false, "")
-	//netAmount            = flag.Bool("net-amount", false, "") 純額は後ほど
+	resume               = flag.Bool("resume", false, "resume the scan from the checkpoint and append to the output.")
+	checkpointPath       = flag.String("checkpoint", "", "path of the checkpoint file. defaults to the output file name with .checkpoint.")
+	checkpointInterval   = flag.Int("checkpoint-interval", 72, "number of snapshots scanned between checkpoints.")
+	workers              = flag.Int("workers", 4, "number of snapshots fetched concurrently.")
+	archiveDir           = flag.String("archive", "", "directory of an archive built by the fetch subcommand to search instead of oanda API.")
+	configPath           = flag.String("config", "", "YAML or JSON profile of searches run instead of the search flags.")
+	searchClientFlags    = registerClientFlags(flag.CommandLine)
+	windowBuckets        = flag.Int("window-buckets", search.DefaultWindow.Buckets, "maximum number of buckets searched on each side of the price. not limited if 0.")
+	windowPips           = flag.Float64("window-pips", 0, "maximum distance in pips from the price to buckets searched. not limited if 0.")
+	report               = flag.String("report", "nearest", "matching windows reported on each side of a snapshot: nearest, all (not overlapping) or overlapping.")
+	mergeInstruments     = flag.Bool("merge-instruments", false, "write the matches of all instruments into a file with instrument column.")
+	netAmount            = flag.Bool("net-amount", false, "search net amounts in favor of the side of each search and output net amounts, long minus short, of buckets. negative limits find buckets dominated by the other side.")
 )
 
-func main() {
-	flag.Parse()
-
-	// TODO:log
-	fmt.Println("period: " + *periodStr)
-	fmt.Println("instrument: " + *instrumentStr)
-	fmt.Println("stop-order: " + *stopOrderStr)
-	fmt.Println("limit-order: " + *limitOrderStr)
-	fmt.Println("losing-osition: " + *losingPositionStr)
-	fmt.Println("profiting-position: " + *profitingPositionStr)
-	fmt.Printf("jp: %t\n", *jp)
+func init() {
+	// -losingPosition is the former name of -losing-position
+	flag.StringVar(losingPositionStr, "losingPosition", "", "deprecated, use -losing-position.")
+}
 
-	// validate oanda-key
-	if len(*oandaKey) == 0 {
-		log.Fatal("oanda-key is required")
-		return
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "fetch":
+			runFetch(args[1:])
+			return
+		case "search":
+			args = args[1:]
+		}
 	}
+	_ = flag.CommandLine.Parse(args) // exits on error
 
-	// validate loc
-	if len(*timeLoc) == 0 {
-		if *timeLoc != "UTC" && *timeLoc != "JST" && *timeLoc != "MT4" {
-			log.Fatalf("invalid time location: %s", *timeLoc)
+	// the instruments of the account are registered before the instruments of the profile are validated
+	var client *oanda.Client
+	var err error
+	if len(*searchClientFlags.account) > 0 {
+		if client, err = searchClientFlags.newClient(); err != nil {
+			log.Fatal(err)
 			return
 		}
+		searchClientFlags.registerInstruments(context.Background(), client)
 	}
 
-	// validate period
-	if len(*periodStr) == 0 {
-		log.Fatal("period is required")
-		return
-	}
-	period := strings.Split(*periodStr, "-")
-	if len(period) != 2 {
-		log.Fatalf("invalid period: %s (ex: 2020/10/01-2020/11/01)", *periodStr)
-		return
+	var p *profile
+	if len(*configPath) > 0 {
+		fmt.Println("config: " + *configPath)
+		p, err = loadProfile(*configPath)
+	} else {
+		p, err = flagProfile()
 	}
-	layout := "2006/01/02"
-	since, err := time.Parse(layout, period[0])
 	if err != nil {
-		log.Fatalf("failed to parse period to date time: %v", period[0])
+		log.Fatal(err)
 		return
 	}
-	until, err := time.Parse(layout, period[1])
+
+	// validate the profile before any search runs
+	since, until, instruments, searches, err := p.validate()
 	if err != nil {
-		log.Fatalf("failed to parse period to date time: %v", period[1])
+		log.Fatal(err)
 		return
 	}
 
-	// validate instrument
-	if len(*instrumentStr) == 0 {
-		log.Fatal("instrument is required")
+	// validate workers
+	if *workers < 1 {
+		log.Fatalf("invalid workers: %d", *workers)
 		return
 	}
-	instrument := oanda.ToInstrument(*instrumentStr)
-	if instrument == oanda.InstrumentUNKNOWN {
-		log.Fatalf("invalid instrument: %s", *instrumentStr)
+
+	// validate checkpoint-interval
+	if *checkpointInterval < 1 {
+		log.Fatalf("invalid checkpoint-interval: %d", *checkpointInterval)
 		return
 	}
 
-	// validate stop-order
-	var stopOrderLowerLimits []float64
-	if len(*stopOrderStr) > 0 {
-		for _, s := range strings.Split(*stopOrderStr, "-") {
-			v, err := strconv.ParseFloat(s, 64)
-			if err != nil {
-				log.Fatalf("invalid stop-order: %s (ex: 0.8-1.0): %v", *stopOrderStr, err)
-				return
-			}
-			stopOrderLowerLimits = append(stopOrderLowerLimits, v)
+	// a file for each instrument, or a file of all instruments with instrument column
+	var outputs [][]oanda.Instrument
+	if p.Output.MergeInstruments {
+		outputs = append(outputs, instruments)
+	} else {
+		for _, instrument := range instruments {
+			outputs = append(outputs, []oanda.Instrument{instrument})
 		}
 	}
-
-	// validate limit-order
-	var limitOrderLowerLimits []float64
-	if len(*limitOrderStr) > 0 {
-		for _, l := range strings.Split(*limitOrderStr, "-") {
-			v, err := strconv.ParseFloat(l, 64)
-			if err != nil {
-				log.Fatalf("invalid limit-order: %s (ex: 0.8-1.0): %v", *limitOrderStr, err)
-				return
-			}
-			limitOrderLowerLimits = append(limitOrderLowerLimits, v)
+	var jobs []searchJob
+	for _, instruments := range outputs {
+		j := searchJob{
+			instruments:    instruments,
+			since:          since,
+			until:          until,
+			period:         p.Period,
+			loc:            p.Loc,
+			jp:             p.Output.JP,
+			instrumentName: p.Output.MergeInstruments,
+			net:            p.NetAmount,
+		}
+		if !p.Output.Split {
+			j.searches = searches
+			j.output = buildFileName(p.Output.Prefix, j.instrumentsString("-"), p.Period)
+			j.searchName = len(searches) > 1
+			jobs = append(jobs, j)
+			continue
+		}
+		for _, s := range searches {
+			j.searches = []namedSearch{s}
+			j.output = buildFileName(p.Output.Prefix+"_"+s.name, j.instrumentsString("-"), p.Period)
+			jobs = append(jobs, j)
 		}
 	}
-
-	// validate losing-position
-	var losingPositionLowerLimits []float64
-	if len(*losingPositionStr) > 0 {
-		for _, l := range strings.Split(*losingPositionStr, "-") {
-			v, err := strconv.ParseFloat(l, 64)
-			if err != nil {
-				log.Fatalf("invalid losing-position: %s (ex: 0.8-1.0): %v", *losingPositionStr, err)
-				return
-			}
-			losingPositionLowerLimits = append(losingPositionLowerLimits, v)
+	for i := range jobs {
+		jobs[i].checkpoint = jobs[i].output + ".checkpoint"
+	}
+	if len(*checkpointPath) > 0 {
+		if len(jobs) > 1 {
+			log.Fatal("checkpoint can not be specified for multiple output files")
+			return
 		}
+		jobs[0].checkpoint = *checkpointPath
 	}
 
-	// validate profiting-position
-	var profitingPositionLowerLimits []float64
-	if len(*profitingPositionStr) > 0 {
-		for _, p := range strings.Split(*profitingPositionStr, "-") {
-			v, err := strconv.ParseFloat(p, 64)
-			if err != nil {
-				log.Fatalf("invalid profiting-position: %s (ex: 0.8-1.0): %v", *profitingPositionStr, err)
+	var source bookSource = archiveSource{archive.New(*archiveDir)}
+	if len(*archiveDir) == 0 {
+		if client == nil {
+			if client, err = searchClientFlags.newClient(); err != nil {
+				log.Fatal(err)
 				return
 			}
-			profitingPositionLowerLimits = append(profitingPositionLowerLimits, v)
 		}
+		source = client
 	}
 
-	if len(stopOrderLowerLimits) == 0 && len(limitOrderLowerLimits) == 0 &&
-		len(profitingPositionLowerLimits) == 0 && len(losingPositionLowerLimits) == 0 {
-		log.Fatalf("at least one of stop-order, limit-order, profiting-position, losing-position is required")
-		return
-	}
+	// stop scanning on SIGINT or SIGTERM
+	ctx, cancel := signalContext()
+	defer cancel()
 
-	var allRecords []record
-	const twentyMinutes = 1200
-	for iTime := since.Unix(); iTime < until.Unix(); iTime += twentyMinutes {
-		t := time.Unix(iTime, 0)
-		client := oanda.NewClient(*oandaKey, "Practice")
-		orderBook, err := client.FetchOrderBook(instrument, &t)
-		if err != nil {
-			log.Printf("failed to fetch order book (at %s): %v", t.String(), err)
-			continue
-		}
-		client = oanda.NewClient(*oandaKey, "Practice")
-		positionBook, err := client.FetchPositionBook(instrument, &t)
-		if err != nil {
-			log.Printf("failed to fetch position book (at %s): %v ", t.String(), err)
-			continue
-		}
-		price := orderBook.Price
-		dateTime := orderBook.Time
-		instrument := orderBook.Instrument
-		const targetRange = 20
-		oShort, oLong, err := orderBook.ExtractBucketVicinityOfPrice(price, targetRange)
-		if err != nil {
-			log.Printf("failed to extract order book buckets: %v", err)
-			continue
+	for _, j := range jobs {
+		if err := runSearch(ctx, source, j); err != nil {
+			log.Fatal(err)
+			return
 		}
-		pShort, pLong, err := positionBook.ExtractBucketVicinityOfPrice(price, targetRange)
-		if err != nil {
-			log.Printf("failed to extract position book buckets: %v", err)
-			continue
+		if ctx.Err() != nil {
+			return
 		}
+	}
+}
 
-		// search applicable stop order
-		var stopOrderRecords []record
-		if len(stopOrderLowerLimits) > 0 {
-			for i := 0; i < targetRange-len(stopOrderLowerLimits); i++ {
-				var shortBuckets []bucket
-				var longBuckets []bucket
-				for j := 0; j < len(stopOrderLowerLimits); j++ {
-					if oShort[i+j].ShortCountPercent >= stopOrderLowerLimits[j] {
-						b := bucket{
-							priceRange:    oShort[i+j].Price,
-							shortOrder:    oShort[i+j].ShortCountPercent,
-							longOrder:     oShort[i+j].LongCountPercent,
-							shortPosition: pShort[i+j].ShortCountPercent,
-							longPosition:  pShort[i+j].LongCountPercent,
-						}
-						shortBuckets = append(shortBuckets, b)
-					}
-					if oLong[i+j].LongCountPercent >= stopOrderLowerLimits[j] {
-						b := bucket{
-							priceRange:    oLong[i+j].Price,
-							shortOrder:    oLong[i+j].ShortCountPercent,
-							longOrder:     oLong[i+j].LongCountPercent,
-							shortPosition: pLong[i+j].ShortCountPercent,
-							longPosition:  pLong[i+j].LongCountPercent,
-						}
-						longBuckets = append(longBuckets, b)
-					}
-				}
-				if len(shortBuckets) == len(stopOrderLowerLimits) {
-					stopOrderRecords = append(stopOrderRecords, record{
-						dateTime:   dateTime,
-						price:      price,
-						instrument: instrument,
-						buckets:    shortBuckets,
-					})
-				}
-				if len(longBuckets) == len(stopOrderLowerLimits) {
-					stopOrderRecords = append(stopOrderRecords, record{
-						dateTime:   dateTime,
-						price:      price,
-						instrument: instrument,
-						buckets:    longBuckets,
-					})
-				}
-				if len(stopOrderRecords) > 0 {
-					break
-				}
-			}
-		}
+// flagProfile builds the profile of a search from the flags.
+func flagProfile() (*profile, error) {
+	// TODO:log
+	fmt.Println("period: " + *periodStr)
+	fmt.Println("instrument: " + *instrumentStr)
+	fmt.Println("stop-order: " + *stopOrderStr)
+	fmt.Println("limit-order: " + *limitOrderStr)
+	fmt.Println("losing-position: " + *losingPositionStr)
+	fmt.Println("profiting-position: " + *profitingPositionStr)
+	fmt.Println("query: " + *queryStr)
+	fmt.Println("combine: " + *combineStr)
+	fmt.Println("not: " + *notStr)
+	fmt.Printf("window-buckets: %d\n", *windowBuckets)
+	fmt.Printf("window-pips: %v\n", *windowPips)
+	fmt.Println("report: " + *report)
+	fmt.Printf("net-amount: %t\n", *netAmount)
+	fmt.Printf("jp: %t\n", *jp)
+	fmt.Printf("merge-instruments: %t\n", *mergeInstruments)
 
-		// search applicable limit order
-		var limitOrderRecords []record
-		if len(limitOrderLowerLimits) > 0 {
-			for i := 0; i < targetRange-len(limitOrderLowerLimits); i++ {
-				var shortBuckets []bucket
-				var longBuckets []bucket
-				for j := 0; j < len(limitOrderLowerLimits); j++ {
-					if oShort[i+j].LongCountPercent >= limitOrderLowerLimits[j] {
-						b := bucket{
-							priceRange:    oShort[i+j].Price,
-							shortOrder:    oShort[i+j].ShortCountPercent,
-							longOrder:     oShort[i+j].LongCountPercent,
-							shortPosition: pShort[i+j].ShortCountPercent,
-							longPosition:  pShort[i+j].LongCountPercent,
-						}
-						shortBuckets = append(shortBuckets, b)
-					}
-					if oLong[i+j].ShortCountPercent >= limitOrderLowerLimits[j] {
-						b := bucket{
-							priceRange:    oLong[i+j].Price,
-							shortOrder:    oLong[i+j].ShortCountPercent,
-							longOrder:     oLong[i+j].LongCountPercent,
-							shortPosition: pLong[i+j].ShortCountPercent,
-							longPosition:  pLong[i+j].LongCountPercent,
-						}
-						longBuckets = append(longBuckets, b)
-					}
-				}
-				if len(shortBuckets) == len(limitOrderLowerLimits) {
-					limitOrderRecords = append(limitOrderRecords, record{
-						dateTime:   dateTime,
-						price:      price,
-						instrument: instrument,
-						buckets:    shortBuckets,
-					})
-				}
-				if len(longBuckets) == len(limitOrderLowerLimits) {
-					limitOrderRecords = append(limitOrderRecords, record{
-						dateTime:   dateTime,
-						price:      price,
-						instrument: instrument,
-						buckets:    longBuckets,
-					})
-				}
-				if len(limitOrderRecords) > 0 {
-					break
-				}
-			}
-		}
+	s := profileSearch{Query: *queryStr, Combine: *combineStr}
+	var err error
+	if s.StopOrder, err = parseLowerLimits("stop-order", *stopOrderStr); err != nil {
+		return nil, err
+	}
+	if s.LimitOrder, err = parseLowerLimits("limit-order", *limitOrderStr); err != nil {
+		return nil, err
+	}
+	if s.LosingPosition, err = parseLowerLimits("losing-position", *losingPositionStr); err != nil {
+		return nil, err
+	}
+	if s.ProfitingPosition, err = parseLowerLimits("profiting-position", *profitingPositionStr); err != nil {
+		return nil, err
+	}
+	if len(*notStr) > 0 {
+		s.Not = strings.Split(*notStr, ",")
+	}
+	p := &profile{
+		Period:    *periodStr,
+		Loc:       *timeLoc,
+		Window:    &profileWindow{Buckets: *windowBuckets, Pips: *windowPips},
+		Report:    *report,
+		NetAmount: *netAmount,
+		Output:    profileOutput{Prefix: *fileNamePrefix, JP: *jp, MergeInstruments: *mergeInstruments},
+		Searches:  []profileSearch{s},
+	}
+	if len(*instrumentStr) > 0 {
+		p.Instruments = strings.Split(*instrumentStr, ",")
+	}
+	return p, nil
+}
 
-		// search applicable losing position
-		var losingPositionRecords []record
-		if len(losingPositionLowerLimits) > 0 {
-			for i := 0; i < targetRange-len(losingPositionLowerLimits); i++ {
-				var shortBuckets []bucket
-				var longBuckets []bucket
-				for j := 0; j < len(losingPositionLowerLimits); j++ {
-					if oShort[i+j].ShortCountPercent >= losingPositionLowerLimits[j] {
-						b := bucket{
-							priceRange:    oShort[i+j].Price,
-							shortOrder:    oShort[i+j].ShortCountPercent,
-							longOrder:     oShort[i+j].LongCountPercent,
-							shortPosition: pShort[i+j].ShortCountPercent,
-							longPosition:  pShort[i+j].LongCountPercent,
-						}
-						shortBuckets = append(shortBuckets, b)
-					}
-					if oLong[i+j].LongCountPercent >= losingPositionLowerLimits[j] {
-						b := bucket{
-							priceRange:    oLong[i+j].Price,
-							shortOrder:    oLong[i+j].ShortCountPercent,
-							longOrder:     oLong[i+j].LongCountPercent,
-							shortPosition: pLong[i+j].ShortCountPercent,
-							longPosition:  pLong[i+j].LongCountPercent,
-						}
-						longBuckets = append(longBuckets, b)
-					}
-				}
-				if len(shortBuckets) == len(losingPositionLowerLimits) {
-					losingPositionRecords = append(losingPositionRecords, record{
-						dateTime:   dateTime,
-						price:      price,
-						instrument: instrument,
-						buckets:    shortBuckets,
-					})
-				}
-				if len(longBuckets) == len(losingPositionLowerLimits) {
-					losingPositionRecords = append(losingPositionRecords, record{
-						dateTime:   dateTime,
-						price:      price,
-						instrument: instrument,
-						buckets:    longBuckets,
-					})
-				}
-				if len(losingPositionRecords) > 0 {
-					break
-				}
-			}
-		}
+// searchJob is a scan of instruments written into an output file.
+type searchJob struct {
+	instruments  []oanda.Instrument
+	since, until time.Time
+	period       string
+	searches     []namedSearch
+	output       string
+	checkpoint   string
+	loc          string
+	jp           bool
+	// instrumentName adds instrument column to the output
+	instrumentName bool
+	// searchName adds search-name column to the output
+	searchName bool
+	// net writes net amounts of buckets
+	net bool
+}
 
-		// search applicable profiting position
-		var profitingPositionRecords []record
-		if len(profitingPositionLowerLimits) > 0 {
-			for i := 0; i < targetRange-len(profitingPositionLowerLimits); i++ {
-				var shortBuckets []bucket
-				var longBuckets []bucket
-				for j := 0; j < len(profitingPositionLowerLimits); j++ {
-					if oShort[i+j].LongCountPercent >= profitingPositionLowerLimits[j] {
-						b := bucket{
-							priceRange:    oShort[i+j].Price,
-							shortOrder:    oShort[i+j].ShortCountPercent,
-							longOrder:     oShort[i+j].LongCountPercent,
-							shortPosition: pShort[i+j].ShortCountPercent,
-							longPosition:  pShort[i+j].LongCountPercent,
-						}
-						shortBuckets = append(shortBuckets, b)
-					}
-					if oLong[i+j].ShortCountPercent >= profitingPositionLowerLimits[j] {
-						b := bucket{
-							priceRange:    oLong[i+j].Price,
-							shortOrder:    oLong[i+j].ShortCountPercent,
-							longOrder:     oLong[i+j].LongCountPercent,
-							shortPosition: pLong[i+j].ShortCountPercent,
-							longPosition:  pLong[i+j].LongCountPercent,
-						}
-						longBuckets = append(longBuckets, b)
-					}
-				}
-				if len(shortBuckets) == len(profitingPositionLowerLimits) {
-					profitingPositionRecords = append(profitingPositionRecords, record{
-						dateTime:   dateTime,
-						price:      price,
-						instrument: instrument,
-						buckets:    shortBuckets,
-					})
-				}
-				if len(longBuckets) == len(profitingPositionLowerLimits) {
-					profitingPositionRecords = append(profitingPositionRecords, record{
-						dateTime:   dateTime,
-						price:      price,
-						instrument: instrument,
-						buckets:    longBuckets,
-					})
-				}
-				if len(profitingPositionLowerLimits) > 0 {
-					break
-				}
+// instrumentsString returns the instruments of j joined with sep.
+func (j searchJob) instrumentsString(sep string) string {
+	var s []string
+	for _, instrument := range j.instruments {
+		s = append(s, string(instrument))
+	}
+	return strings.Join(s, sep)
+}
+
+// runSearch runs the scan of j. It returns without an error when ctx is done and keeps the checkpoint
+// to resume the scan with -resume. The checkpoint is also kept if the output fails to be written.
+func runSearch(ctx context.Context, source bookSource, j searchJob) error {
+	since := j.since
+	cp := &checkpoint{
+		Instrument:    j.instrumentsString(","),
+		Period:        j.period,
+		Search:        searchesString(j.searches),
+		LastCompleted: since.Add(-20 * time.Minute),
+	}
+	if *resume {
+		if _, err := os.Stat(j.checkpoint); os.IsNotExist(err) {
+			// the scan finished and removed the checkpoint
+			if _, err := os.Stat(j.output); err == nil {
+				log.Printf("%s is already complete", j.output)
+				return nil
 			}
 		}
-
-		allRecords = append(allRecords, stopOrderRecords...)
-		allRecords = append(allRecords, limitOrderRecords...)
-		allRecords = append(allRecords, losingPositionRecords...)
-		allRecords = append(allRecords, profitingPositionRecords...)
+		var err error
+		cp, err = loadCheckpoint(j.checkpoint)
+		if err != nil {
+			return err
+		}
+		if err := cp.matches(j.instrumentsString(","), j.period, searchesString(j.searches)); err != nil {
+			return err
+		}
+		since = cp.LastCompleted.Add(20 * time.Minute)
+		log.Printf("resume %s from %s", j.output, since.String())
 	}
 
-	// open file
-	f, err := os.Create(buildFileName(*fileNamePrefix, *instrumentStr, *periodStr))
+	// open file, the output of the previous run is appended on resume
+	f, err := os.OpenFile(j.output, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		log.Fatalf("failed to create file: %v", err)
-		return
+		return fmt.Errorf("failed to create file: %v", err)
 	}
 	defer lib.SafeClose(f)
-
-	// write csv
-	baseHeader := []string{fmt.Sprintf("date-time (%s)", *timeLoc), "price"}
-	bucketHeader := []string{"price-range", "short-order", "long-order", "short-position", "long-position"}
-	bucketHeaderMaxSize := len(stopOrderLowerLimits)
-	if bucketHeaderMaxSize < len(limitOrderLowerLimits) {
-		bucketHeaderMaxSize = len(limitOrderLowerLimits)
-	}
-	if bucketHeaderMaxSize < len(losingPositionLowerLimits) {
-		bucketHeaderMaxSize = len(losingPositionLowerLimits)
+	// records written after the checkpoint are scanned again
+	if err := f.Truncate(cp.OutputSize); err != nil {
+		return fmt.Errorf("failed to truncate file: %v", err)
 	}
-	if bucketHeaderMaxSize < len(profitingPositionLowerLimits) {
-		bucketHeaderMaxSize = len(profitingPositionLowerLimits)
+	if _, err := f.Seek(cp.OutputSize, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek file: %v", err)
 	}
-	if err := writeCSV(f, baseHeader, bucketHeader, bucketHeaderMaxSize, allRecords); err != nil {
-		log.Fatalf("failed to write csv: %v", err)
-	}
-	return
-}
 
-func writeCSV(f io.Writer, baseHeader, bucketHeader []string, bucketHeaderMaxSize int, records []record) error {
-
-	// build header
-	header := baseHeader
-	for i := 0; i < bucketHeaderMaxSize; i++ {
-		var h []string
-		for _, s := range bucketHeader {
-			h = append(h, fmt.Sprintf("%s-%d", s, i))
+	// write csv header
+	w := newRecordWriter(f, j.jp, j.loc)
+	w.instrument = j.instrumentName
+	w.searchName = j.searchName
+	w.net = j.net
+	if cp.OutputSize == 0 {
+		baseHeader, bucketHeader := recordHeader(j.loc, j.instrumentName, j.searchName, j.net)
+		bucketSize := 0
+		for _, s := range j.searches {
+			if bucketSize < s.limits.bucketSize() {
+				bucketSize = s.limits.bucketSize()
+			}
+		}
+		if err := w.WriteHeader(baseHeader, bucketHeader, bucketSize); err != nil {
+			return fmt.Errorf("failed to write csv: %v", err)
+		}
+	}
+	save := func() error {
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to write csv: %v", err)
 		}
-		header = append(header, h...)
-	}
-
-	// build records
-	var csvRecords [][]string
-	csvRecords = append(csvRecords, header)
-	for _, r := range records {
-		var bucketRecord []string
-		for _, b := range r.buckets {
-			bucketRecord = append(bucketRecord, b.priceRange.PriceStr(r.instrument))
-			bucketRecord = append(bucketRecord, strconv.FormatFloat(b.shortOrder, 'f', 2, 64))
-			bucketRecord = append(bucketRecord, strconv.FormatFloat(b.longOrder, 'f', 2, 64))
-			bucketRecord = append(bucketRecord, strconv.FormatFloat(b.shortPosition, 'f', 2, 64))
-			bucketRecord = append(bucketRecord, strconv.FormatFloat(b.longPosition, 'f', 2, 64))
+		size, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("failed to seek file: %v", err)
 		}
-		csvRecords = append(csvRecords, append([]string{timeString(r.dateTime, *timeLoc), r.price.PriceStr(r.instrument)}, bucketRecord...))
+		cp.OutputSize = size
+		return saveCheckpoint(j.checkpoint, cp)
+	}
+	if err := save(); err != nil {
+		return err
 	}
 
-	// write csv
-	w := csv.NewWriter(f)
-	if *jp {
-		w = csv.NewWriter(transform.NewWriter(f, japanese.ShiftJIS.NewEncoder()))
+	// stream records to the file and save the checkpoint every checkpoint-interval snapshots.
+	// the scan stops if the file fails to be written, keeping the checkpoint saved last to resume it.
+	scanCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	var writeErr error
+	n := 0
+	scan(scanCtx, source, j.instruments, since, j.until, j.searches, *workers, func(snapshot time.Time, matches []search.Match) {
+		if writeErr != nil {
+			return
+		}
+		if err := w.Write(matches); err != nil {
+			writeErr = fmt.Errorf("failed to write csv: %v", err)
+			stop()
+			return
+		}
+		cp.LastCompleted = snapshot
+		if n++; n%*checkpointInterval == 0 {
+			if err := save(); err != nil {
+				log.Printf("failed to save checkpoint: %v", err)
+			}
+		}
+	})
+	if writeErr != nil {
+		return writeErr
 	}
-	if err := w.WriteAll(csvRecords); err != nil {
+	if err := save(); err != nil {
 		return err
 	}
-	w.Flush()
+
+	// keep the checkpoint only if the scan is interrupted
+	if ctx.Err() == nil {
+		if err := os.Remove(j.checkpoint); err != nil {
+			log.Printf("failed to remove checkpoint: %v", err)
+		}
+		return nil
+	}
+	log.Printf("scanned %s until %s, run again with -resume to continue", j.output, cp.LastCompleted.String())
 	return nil
 }
 
@@ -481,17 +398,114 @@ func buildFileName(prefix, instrument, period string) string {
 	return fmt.Sprintf("%s_%s_%s.csv", prefix, instrument, strings.Replace(period, "/", "", -1))
 }
 
-type record struct {
-	dateTime   time.Time
-	price      oanda.Price
-	instrument oanda.Instrument
-	buckets    []bucket
+// searchLimits holds the lower limits of each search. A search is disabled if its limits are empty.
+// The query is searched too if it is not nil.
+// The searches are composed with combine if it is and or or, and the searches in not are negated then.
+// The limits are compared with net amounts of buckets if net is true.
+// The buckets within window are searched, which is search.DefaultWindow if it is nil.
+type searchLimits struct {
+	stopOrder         []float64
+	limitOrder        []float64
+	losingPosition    []float64
+	profitingPosition []float64
+	query             *search.Query
+	combine           string
+	not               []string
+	net               bool
+	window            *search.Window
+}
+
+// String returns the conditions of the searches.
+func (l searchLimits) String() string {
+	s := fmt.Sprintf("stop-order=%v limit-order=%v losing-position=%v profiting-position=%v",
+		l.stopOrder, l.limitOrder, l.losingPosition, l.profitingPosition)
+	if l.query != nil {
+		s += fmt.Sprintf(" query=%q", l.query.Name())
+	}
+	if len(l.combine) > 0 {
+		s += fmt.Sprintf(" combine=%s not=%v", l.combine, l.not)
+	}
+	if l.net {
+		s += " net-amount"
+	}
+	if l.window != nil && *l.window != search.DefaultWindow {
+		s += " window=" + l.window.String()
+	}
+	return s
+}
+
+// searches returns the enabled searches.
+func (l searchLimits) searches() []search.Condition {
+	var conditions []search.Condition
+	if len(l.stopOrder) > 0 {
+		conditions = append(conditions, search.StopOrder{LowerLimits: l.stopOrder, Net: l.net})
+	}
+	if len(l.limitOrder) > 0 {
+		conditions = append(conditions, search.LimitOrder{LowerLimits: l.limitOrder, Net: l.net})
+	}
+	if len(l.losingPosition) > 0 {
+		conditions = append(conditions, search.LosingPosition{LowerLimits: l.losingPosition, Net: l.net})
+	}
+	if len(l.profitingPosition) > 0 {
+		conditions = append(conditions, search.ProfitingPosition{LowerLimits: l.profitingPosition, Net: l.net})
+	}
+	if l.query != nil {
+		conditions = append(conditions, l.query)
+	}
+	return conditions
+}
+
+// enabled reports whether the search of name is enabled.
+func (l searchLimits) enabled(name string) bool {
+	for _, c := range l.searches() {
+		if c.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// conditions returns the conditions to search snapshots with.
+func (l searchLimits) conditions() []search.Condition {
+	conditions := l.searches()
+	if len(l.combine) == 0 {
+		return conditions
+	}
+	for i, c := range conditions {
+		for _, name := range l.not {
+			if c.Name() == name {
+				conditions[i] = search.Not{Condition: c}
+			}
+		}
+	}
+	if l.combine == "or" {
+		return []search.Condition{search.Or{Conditions: conditions}}
+	}
+	return []search.Condition{search.And{Conditions: conditions}}
+}
+
+// searchWindow returns the window of the buckets searched.
+func (l searchLimits) searchWindow() search.Window {
+	if l.window == nil {
+		return search.DefaultWindow
+	}
+	return *l.window
 }
 
-type bucket struct {
-	priceRange    oanda.Price
-	shortOrder    float64
-	longOrder     float64
-	shortPosition float64
-	longPosition  float64
+// bucketSize returns the maximum number of buckets in a match.
+func (l searchLimits) bucketSize() int {
+	size := len(l.stopOrder)
+	if size < len(l.limitOrder) {
+		size = len(l.limitOrder)
+	}
+	if size < len(l.losingPosition) {
+		size = len(l.losingPosition)
+	}
+	if size < len(l.profitingPosition) {
+		size = len(l.profitingPosition)
+	}
+	if l.query != nil && size < l.query.Size() {
+		size = l.query.Size()
+	}
+	return size
 }
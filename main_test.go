@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/archive"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda/oandatest"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/search"
+)
+
+var fixtureSince = time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+
+var fixtureLimits = searchLimits{
+	stopOrder:  []float64{0.5, 1.0},
+	limitOrder: []float64{0.5},
+}
+
+const fixtureCSV = "" +
+	"date-time (UTC),price,search-kind,side,distance-pips,offset,conditions,price-range-0,short-order-0,long-order-0,short-position-0,long-position-0,price-range-1,short-order-1,long-order-1,short-position-1,long-position-1\n" +
+	"2020/10/01 00:00:00,105.512,stop-order,below,6.2,2,stop-order,105.400,0.60,0.10,0.35,0.25,105.350,1.20,0.00,0.00,0.00\n" +
+	"2020/10/01 00:20:00,105.538,limit-order,above,11.2,2,limit-order,105.650,0.80,0.00,0.00,0.00\n"
+
+// newFixtureServer serves USD_JPY books from fixtureSince for an hour.
+// The books are expected to be searched with fixtureLimits into fixtureCSV.
+func newFixtureServer() *oandatest.Server {
+	since := fixtureSince
+	srv := oandatest.NewServer()
+
+	// 00:00 stop orders are piled up below price
+	ob := oandatest.NewBook("USD_JPY", since, "105.512", "0.05", 25)
+	ob.Set("105.40", 0.1, 0.6).Set("105.35", 0, 1.2)
+	pb := oandatest.NewBook("USD_JPY", since, "105.512", "0.05", 25)
+	pb.Set("105.40", 0.25, 0.35)
+	srv.AddOrderBook(ob)
+	srv.AddPositionBook(pb)
+
+	// 00:20 limit orders are piled up above price
+	at := since.Add(20 * time.Minute)
+	ob = oandatest.NewBook("USD_JPY", at, "105.538", "0.05", 25)
+	ob.Set("105.65", 0, 0.8)
+	srv.AddOrderBook(ob)
+	srv.AddPositionBook(oandatest.NewBook("USD_JPY", at, "105.538", "0.05", 25))
+
+	// 00:40 position book is missing
+	at = since.Add(40 * time.Minute)
+	ob = oandatest.NewBook("USD_JPY", at, "105.538", "0.05", 25)
+	ob.Set("105.40", 0, 2.0).Set("105.35", 0, 2.0)
+	srv.AddOrderBook(ob)
+	return srv
+}
+
+// fixtureCSVOf writes the CSV of matches found by limits, with the instrument column if instrument is true.
+func fixtureCSVOf(t *testing.T, matches []search.Match, limits searchLimits, instrument bool) string {
+	var buf bytes.Buffer
+	w := newRecordWriter(&buf, false, "UTC")
+	w.instrument = instrument
+	w.net = limits.net
+	baseHeader, bucketHeader := recordHeader("UTC", instrument, false, limits.net)
+	if err := w.WriteHeader(baseHeader, bucketHeader, limits.bucketSize()); err != nil {
+		t.Fatalf("WriteHeader() error = %v", err)
+	}
+	if err := w.Write(matches); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	return buf.String()
+}
+
+// scanAll scans instruments and returns all matches found.
+func scanAll(ctx context.Context, source bookSource, instruments []oanda.Instrument, since, until time.Time, limits searchLimits, workers int) []search.Match {
+	var matches []search.Match
+	scan(ctx, source, instruments, since, until, []namedSearch{{limits: limits}}, workers, func(_ time.Time, m []search.Match) {
+		matches = append(matches, m...)
+	})
+	return matches
+}
+
+func TestScanAndWriteCSV(t *testing.T) {
+	srv := newFixtureServer()
+	defer srv.Close()
+
+	// a transient error must not drop the snapshot
+	srv.FailNext(1, 503, "")
+
+	client := oanda.NewClient("key",
+		oanda.WithBaseURL(srv.URL),
+		oanda.WithRetryPolicy(oanda.RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond}),
+	)
+	matches := scanAll(context.Background(), client, []oanda.Instrument{oanda.InstrumentUSDJPY}, fixtureSince, fixtureSince.Add(time.Hour), fixtureLimits, 4)
+	if actual := fixtureCSVOf(t, matches, fixtureLimits, false); actual != fixtureCSV {
+		t.Errorf("recordWriter wrote\n%s\nexpected:\n%s", actual, fixtureCSV)
+	}
+}
+
+func TestScan_archive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv := newFixtureServer()
+	client := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.NoRetry))
+	a := archive.New(dir)
+	m, err := a.LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetchArchive(context.Background(), client, a, m, []oanda.Instrument{oanda.InstrumentUSDJPY}, fixtureSince, fixtureSince.Add(time.Hour))
+	srv.Close()
+
+	matches := scanAll(context.Background(), archiveSource{a}, []oanda.Instrument{oanda.InstrumentUSDJPY}, fixtureSince, fixtureSince.Add(time.Hour), fixtureLimits, 4)
+	if actual := fixtureCSVOf(t, matches, fixtureLimits, false); actual != fixtureCSV {
+		t.Errorf("recordWriter wrote\n%s\nexpected:\n%s", actual, fixtureCSV)
+	}
+}
+
+func TestScan_combine(t *testing.T) {
+	srv := newFixtureServer()
+	defer srv.Close()
+	client := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.NoRetry))
+
+	tests := []struct {
+		limits   searchLimits
+		expected []string
+	}{
+		{
+			limits:   searchLimits{stopOrder: []float64{0.5, 1.0}, limitOrder: []float64{0.5}, combine: "and"},
+			expected: nil,
+		},
+		{
+			limits:   searchLimits{stopOrder: []float64{0.5, 1.0}, limitOrder: []float64{0.5}, combine: "and", not: []string{"limit-order"}},
+			expected: []string{"stop-order !limit-order"},
+		},
+		{
+			limits:   searchLimits{stopOrder: []float64{0.5, 1.0}, limitOrder: []float64{0.5}, combine: "or"},
+			expected: []string{"stop-order", "limit-order"},
+		},
+		{
+			limits:   searchLimits{limitOrder: []float64{0.5}, query: mustParseQuery(t, "side=below && order.short[2..3] >= 0.6"), combine: "or"},
+			expected: []string{"side=below && order.short[2..3] >= 0.6", "limit-order"},
+		},
+	}
+	for i, tt := range tests {
+		matches := scanAll(context.Background(), client, []oanda.Instrument{oanda.InstrumentUSDJPY}, fixtureSince, fixtureSince.Add(time.Hour), tt.limits, 4)
+		var actual []string
+		for _, m := range matches {
+			actual = append(actual, strings.Join(m.Conditions, " "))
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("#%d scan() fired %v, expected: %v", i, actual, tt.expected)
+		}
+	}
+}
+
+func TestScanAndWriteCSV_net(t *testing.T) {
+	srv := newFixtureServer()
+	defer srv.Close()
+	client := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.NoRetry))
+
+	// stop orders and limit orders dominate on the opposite sides
+	limits := searchLimits{stopOrder: []float64{0.4}, limitOrder: []float64{0.4}, net: true}
+	matches := scanAll(context.Background(), client, []oanda.Instrument{oanda.InstrumentUSDJPY}, fixtureSince, fixtureSince.Add(time.Hour), limits, 4)
+	expected := "" +
+		"date-time (UTC),price,search-kind,side,distance-pips,offset,conditions,price-range-0,net-order-0,net-position-0\n" +
+		"2020/10/01 00:00:00,105.512,stop-order,below,6.2,2,stop-order,105.400,-0.50,-0.10\n" +
+		"2020/10/01 00:20:00,105.538,limit-order,above,11.2,2,limit-order,105.650,-0.80,0.00\n"
+	if actual := fixtureCSVOf(t, matches, limits, false); actual != expected {
+		t.Errorf("recordWriter wrote\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestScanAndWriteCSV_instruments(t *testing.T) {
+	srv := newFixtureServer()
+	defer srv.Close()
+	// 00:20 stop orders are piled up above price of EUR_USD
+	at := fixtureSince.Add(20 * time.Minute)
+	ob := oandatest.NewBook("EUR_USD", at, "1.18478", "0.0005", 25)
+	ob.Set("1.1860", 0.6, 0)
+	srv.AddOrderBook(ob)
+	srv.AddPositionBook(oandatest.NewBook("EUR_USD", at, "1.18478", "0.0005", 25))
+	client := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.NoRetry))
+
+	limits := searchLimits{stopOrder: []float64{0.5}}
+	instruments := []oanda.Instrument{oanda.InstrumentUSDJPY, oanda.InstrumentEURUSD}
+	matches := scanAll(context.Background(), client, instruments, fixtureSince, fixtureSince.Add(time.Hour), limits, 4)
+	expected := "" +
+		"date-time (UTC),price,instrument,search-kind,side,distance-pips,offset,conditions,price-range-0,short-order-0,long-order-0,short-position-0,long-position-0\n" +
+		"2020/10/01 00:00:00,105.512,USD_JPY,stop-order,below,6.2,2,stop-order,105.400,0.60,0.10,0.35,0.25\n" +
+		"2020/10/01 00:20:00,1.18478,EUR_USD,stop-order,above,12.2,2,stop-order,1.18600,0.00,0.60,0.00,0.00\n"
+	if actual := fixtureCSVOf(t, matches, limits, true); actual != expected {
+		t.Errorf("recordWriter wrote\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func mustParseQuery(t *testing.T, src string) *search.Query {
+	q, err := search.ParseQuery(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestScan_canceled(t *testing.T) {
+	since := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	srv := oandatest.NewServer()
+	defer srv.Close()
+	srv.SetLatency(time.Second)
+
+	client := oanda.NewClient("key", oanda.WithBaseURL(srv.URL))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	matches := scanAll(ctx, client, []oanda.Instrument{oanda.InstrumentUSDJPY}, since, since.Add(24*time.Hour), searchLimits{stopOrder: []float64{0.5}}, 4)
+	if len(matches) != 0 {
+		t.Errorf("scan() = %v, want no matches", matches)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("scan() returned after %v, want it to stop when ctx is done", elapsed)
+	}
+}
+
+// cancelingSource cancels the scan when it is asked for the n-th order book.
+type cancelingSource struct {
+	bookSource
+	mu     sync.Mutex
+	n      int
+	cancel context.CancelFunc
+}
+
+func (s *cancelingSource) FetchOrderBookContext(ctx context.Context, instrument oanda.Instrument, dateTime *time.Time) (*oanda.Book, error) {
+	s.mu.Lock()
+	if s.n--; s.n == 0 {
+		s.cancel()
+	}
+	s.mu.Unlock()
+	return s.bookSource.FetchOrderBookContext(ctx, instrument, dateTime)
+}
+
+func TestRunSearch_resume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(r bool, w, n int) { *resume, *workers, *checkpointInterval = r, w, n }(*resume, *workers, *checkpointInterval)
+	*workers, *checkpointInterval = 2, 2
+
+	// stop orders are piled up below price in every snapshot for 4 hours
+	srv := oandatest.NewServer()
+	defer srv.Close()
+	until := fixtureSince.Add(4 * time.Hour)
+	for at := fixtureSince; at.Before(until); at = at.Add(20 * time.Minute) {
+		ob := oandatest.NewBook("USD_JPY", at, "105.512", "0.05", 25)
+		ob.Set("105.40", 0.1, 0.6).Set("105.35", 0, 1.2)
+		srv.AddOrderBook(ob)
+		srv.AddPositionBook(oandatest.NewBook("USD_JPY", at, "105.512", "0.05", 25))
+	}
+	client := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.NoRetry), oanda.WithRateLimit(0, 0))
+	job := func(output string) searchJob {
+		return searchJob{
+			instruments: []oanda.Instrument{oanda.InstrumentUSDJPY},
+			since:       fixtureSince,
+			until:       until,
+			period:      "20201001-20201001",
+			searches:    []namedSearch{{limits: fixtureLimits}},
+			output:      filepath.Join(dir, output),
+			checkpoint:  filepath.Join(dir, output+".checkpoint"),
+			loc:         "UTC",
+		}
+	}
+
+	// the output of the scan which is not interrupted
+	*resume = false
+	if err := runSearch(context.Background(), client, job("expected.csv")); err != nil {
+		t.Fatalf("runSearch() error = %v", err)
+	}
+	expected, err := ioutil.ReadFile(filepath.Join(dir, "expected.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(expected), "\n"); n != 13 {
+		t.Fatalf("runSearch() wrote %d lines, want the header and 12 records", n)
+	}
+
+	// interrupted in the middle of the scan
+	j := job("resumed.csv")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := runSearch(ctx, &cancelingSource{bookSource: client, n: 6, cancel: cancel}, j); err != nil {
+		t.Fatalf("runSearch() error = %v", err)
+	}
+	interrupted, err := ioutil.ReadFile(j.output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interrupted) >= len(expected) || !strings.HasPrefix(string(expected), string(interrupted)) {
+		t.Fatalf("interrupted runSearch() wrote\n%s\nwant a part of\n%s", interrupted, expected)
+	}
+	if _, err := os.Stat(j.checkpoint); err != nil {
+		t.Fatalf("checkpoint is not kept: %v", err)
+	}
+	// records written after the checkpoint before a crash are discarded on resume
+	f, err := os.OpenFile(j.output, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("2020/10/01 03:40:00,105.512,partial"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	*resume = true
+	for i := 0; i < 2; i++ {
+		// the second run finds the scan already complete
+		if err := runSearch(context.Background(), client, j); err != nil {
+			t.Fatalf("#%d resumed runSearch() error = %v", i, err)
+		}
+		actual, err := ioutil.ReadFile(j.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != string(expected) {
+			t.Errorf("#%d resumed runSearch() wrote\n%s\nexpected:\n%s", i, actual, expected)
+		}
+		if _, err := os.Stat(j.checkpoint); !os.IsNotExist(err) {
+			t.Errorf("#%d checkpoint is not removed: %v", i, err)
+		}
+	}
+}
+
+func TestLosingPositionAlias(t *testing.T) {
+	defer func(v string) { *losingPositionStr = v }(*losingPositionStr)
+	if err := flag.Set("losingPosition", "0.5-1.0"); err != nil {
+		t.Fatalf("flag.Set() error = %v", err)
+	}
+	if *losingPositionStr != "0.5-1.0" {
+		t.Errorf("-losingPosition set -losing-position to %q, expected: 0.5-1.0", *losingPositionStr)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/search"
+	"gopkg.in/yaml.v2"
+)
+
+// profile is a set of searches run at once. It is loaded from a YAML or JSON file given by -config,
+// or built from the flags.
+type profile struct {
+	// Instruments are the instruments scanned. all is the major instruments.
+	Instruments []string `yaml:"instruments"`
+	Period      string   `yaml:"period"`
+	Loc         string   `yaml:"loc"`
+	// Window is the window of buckets searched. search.DefaultWindow is searched if it is nil.
+	Window *profileWindow `yaml:"window"`
+	// Report is the matching windows reported on each side of a snapshot: nearest (default), all or overlapping.
+	Report string `yaml:"report"`
+	// NetAmount searches net amounts in favor of the side of each search and writes net amounts
+	// of buckets, long minus short.
+	NetAmount bool            `yaml:"net-amount"`
+	Output    profileOutput   `yaml:"output"`
+	Searches  []profileSearch `yaml:"searches"`
+}
+
+type profileOutput struct {
+	// Prefix is the prefix of the output file names.
+	Prefix string `yaml:"prefix"`
+	JP     bool   `yaml:"jp"`
+	// Split writes the matches of each search into its own file instead of a file with search-name column.
+	Split bool `yaml:"split"`
+	// MergeInstruments writes the matches of all instruments into a file with instrument column
+	// instead of a file for each instrument.
+	MergeInstruments bool `yaml:"merge-instruments"`
+}
+
+// profileWindow limits the buckets searched on each side of the price. A limit of 0 is not limited.
+type profileWindow struct {
+	Buckets int     `yaml:"buckets"`
+	Pips    float64 `yaml:"pips"`
+}
+
+// profileSearch is a named search. The limits are written as lists such as [0.8, 1.0].
+type profileSearch struct {
+	Name              string    `yaml:"name"`
+	StopOrder         []float64 `yaml:"stop-order"`
+	LimitOrder        []float64 `yaml:"limit-order"`
+	LosingPosition    []float64 `yaml:"losing-position"`
+	ProfitingPosition []float64 `yaml:"profiting-position"`
+	Query             string    `yaml:"query"`
+	Combine           string    `yaml:"combine"`
+	Not               []string  `yaml:"not"`
+}
+
+// namedSearch is a validated search of a profile. Its matches are written with the name.
+type namedSearch struct {
+	name   string
+	limits searchLimits
+}
+
+// loadProfile reads the profile file at path. JSON is read as YAML.
+func loadProfile(path string) (*profile, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	var p profile
+	if err := yaml.UnmarshalStrict(body, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+	if len(p.Loc) == 0 {
+		p.Loc = "UTC"
+	}
+	if len(p.Output.Prefix) == 0 {
+		p.Output.Prefix = "ob-search"
+	}
+	return &p, nil
+}
+
+// validate checks the whole profile and returns the period, instruments and searches to run.
+func (p *profile) validate() (since, until time.Time, instruments []oanda.Instrument, searches []namedSearch, err error) {
+	since, until, err = parsePeriod(p.Period)
+	if err != nil {
+		return since, until, nil, nil, err
+	}
+	if len(p.Instruments) == 0 {
+		return since, until, nil, nil, errors.New("instrument is required")
+	}
+	if instruments, err = toInstruments(p.Instruments); err != nil {
+		return since, until, nil, nil, err
+	}
+	if p.Loc != "UTC" && p.Loc != "JST" && p.Loc != "MT4" {
+		return since, until, nil, nil, fmt.Errorf("invalid time location: %s", p.Loc)
+	}
+	window := search.DefaultWindow
+	if p.Window != nil {
+		if p.Window.Buckets < 0 || p.Window.Pips < 0 {
+			return since, until, nil, nil, fmt.Errorf("invalid window: buckets and pips must not be negative")
+		}
+		window = search.Window{Buckets: p.Window.Buckets, Pips: oanda.Pips(p.Window.Pips)}
+	}
+	if len(p.Report) > 0 {
+		if window.Report, err = search.ParseReport(p.Report); err != nil {
+			return since, until, nil, nil, err
+		}
+	}
+	if len(p.Searches) == 0 {
+		return since, until, nil, nil, errors.New("at least one search is required")
+	}
+	names := map[string]bool{}
+	for i, s := range p.Searches {
+		if len(p.Searches) > 1 {
+			if len(s.Name) == 0 {
+				return since, until, nil, nil, fmt.Errorf("name of searches[%d] is required", i)
+			}
+			if strings.ContainsAny(s.Name, `/\:*?"<>| `) {
+				return since, until, nil, nil, fmt.Errorf("invalid name of searches[%d]: %q can not be used in a file name", i, s.Name)
+			}
+			if names[s.Name] {
+				return since, until, nil, nil, fmt.Errorf("duplicated search name: %s", s.Name)
+			}
+			names[s.Name] = true
+		}
+		limits, err := s.limits(p.NetAmount)
+		limits.window = &window
+		if err != nil {
+			if len(s.Name) > 0 {
+				return since, until, nil, nil, fmt.Errorf("invalid search %s: %v", s.Name, err)
+			}
+			return since, until, nil, nil, err
+		}
+		searches = append(searches, namedSearch{name: s.Name, limits: limits})
+	}
+	return since, until, instruments, searches, nil
+}
+
+// limits validates the search and builds searchLimits comparing net amounts if net is true.
+// Negative limits are only valid for net amounts since percentages are never negative.
+func (s profileSearch) limits(net bool) (searchLimits, error) {
+	l := searchLimits{
+		stopOrder:         s.StopOrder,
+		limitOrder:        s.LimitOrder,
+		losingPosition:    s.LosingPosition,
+		profitingPosition: s.ProfitingPosition,
+		combine:           s.Combine,
+		net:               net,
+	}
+	if !net {
+		for _, kind := range []struct {
+			name   string
+			limits []float64
+		}{
+			{"stop-order", l.stopOrder},
+			{"limit-order", l.limitOrder},
+			{"losing-position", l.losingPosition},
+			{"profiting-position", l.profitingPosition},
+		} {
+			for _, v := range kind.limits {
+				if v < 0 {
+					return l, fmt.Errorf("invalid %s: negative limit %v requires net-amount", kind.name, v)
+				}
+			}
+		}
+	}
+	if len(s.Query) > 0 {
+		q, err := search.ParseQuery(s.Query)
+		if err != nil {
+			var qe *search.QueryError
+			if errors.As(err, &qe) {
+				return l, fmt.Errorf("invalid query: %v\n%s", err, qe.Caret())
+			}
+			return l, fmt.Errorf("invalid query: %v", err)
+		}
+		l.query = q
+	}
+	if len(l.searches()) == 0 {
+		return l, errors.New("at least one of stop-order, limit-order, profiting-position, losing-position, query is required")
+	}
+	if s.Combine != "" && s.Combine != "and" && s.Combine != "or" {
+		return l, fmt.Errorf("invalid combine: %s (and or or)", s.Combine)
+	}
+	if len(s.Not) > 0 {
+		if len(s.Combine) == 0 {
+			return l, errors.New("not requires combine")
+		}
+		for _, kind := range s.Not {
+			if !l.enabled(kind) {
+				return l, fmt.Errorf("invalid not: %s is not searched", kind)
+			}
+			l.not = append(l.not, kind)
+		}
+	}
+	return l, nil
+}
+
+// parseLowerLimits parses the lower limits of a search flag such as 0.8-1.0.
+// Negative limits of net amounts are written with another dash such as -0.3--0.5.
+func parseLowerLimits(name, str string) ([]float64, error) {
+	var lowerLimits []float64
+	if len(str) == 0 {
+		return nil, nil
+	}
+	negative := false
+	for _, s := range strings.Split(str, "-") {
+		// an empty element is the sign of the next limit
+		if len(s) == 0 && !negative {
+			negative = true
+			continue
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s (ex: 0.8-1.0): %v", name, str, err)
+		}
+		if negative {
+			v = -v
+		}
+		negative = false
+		lowerLimits = append(lowerLimits, v)
+	}
+	if negative {
+		return nil, fmt.Errorf("invalid %s: %s (ex: 0.8-1.0): missing limit after -", name, str)
+	}
+	return lowerLimits, nil
+}
+
+// searchesString returns the conditions of the searches.
+func searchesString(searches []namedSearch) string {
+	if len(searches) == 1 && len(searches[0].name) == 0 {
+		return searches[0].limits.String()
+	}
+	var s []string
+	for _, ns := range searches {
+		s = append(s, ns.name+": "+ns.limits.String())
+	}
+	return strings.Join(s, "; ")
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/search"
+)
+
+const fixtureProfileYAML = `
+instruments: [USD_JPY, EUR_USD]
+period: 2020/10/01-2020/10/02
+loc: JST
+window:
+  buckets: 30
+  pips: 50
+report: all
+output:
+  prefix: weekly
+  jp: true
+searches:
+  - name: stop
+    stop-order: [0.5, 1.0]
+  - name: limit-without-stop
+    stop-order: [0.5]
+    limit-order: [0.5]
+    combine: and
+    not: [stop-order]
+  - name: query
+    query: side=above && order.short[0..1] >= 0.8
+`
+
+const fixtureProfileJSON = `{
+  "instruments": ["USD_JPY"],
+  "period": "2020/10/01-2020/10/02",
+  "searches": [{"name": "stop", "stop-order": [0.5, 1.0]}]
+}`
+
+func writeProfile(t *testing.T, dir, name, body string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := loadProfile(writeProfile(t, dir, "profile.yaml", fixtureProfileYAML))
+	if err != nil {
+		t.Fatalf("loadProfile() error = %v", err)
+	}
+	since, until, instruments, searches, err := p.validate()
+	if err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	if expected := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC); !since.Equal(expected) {
+		t.Errorf("validate() since = %v, expected: %v", since, expected)
+	}
+	if expected := time.Date(2020, 10, 2, 0, 0, 0, 0, time.UTC); !until.Equal(expected) {
+		t.Errorf("validate() until = %v, expected: %v", until, expected)
+	}
+	if expected := []oanda.Instrument{oanda.InstrumentUSDJPY, oanda.InstrumentEURUSD}; !reflect.DeepEqual(instruments, expected) {
+		t.Errorf("validate() instruments = %v, expected: %v", instruments, expected)
+	}
+	if p.Loc != "JST" || p.Output.Prefix != "weekly" || !p.Output.JP || p.Output.Split {
+		t.Errorf("loadProfile() = %+v", p)
+	}
+	var names []string
+	for _, s := range searches {
+		names = append(names, s.name)
+	}
+	if expected := []string{"stop", "limit-without-stop", "query"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("validate() searches = %v, expected: %v", names, expected)
+	}
+	if expected := []string{"stop-order"}; !reflect.DeepEqual(searches[1].limits.not, expected) {
+		t.Errorf("validate() not = %v, expected: %v", searches[1].limits.not, expected)
+	}
+	if expected := (search.Window{Buckets: 30, Pips: 50, Report: search.ReportAll}); searches[0].limits.searchWindow() != expected {
+		t.Errorf("validate() window = %v, expected: %v", searches[0].limits.searchWindow(), expected)
+	}
+	if searches[2].limits.query == nil || searches[2].limits.bucketSize() != 2 {
+		t.Errorf("validate() query = %v, expected a query of 2 buckets", searches[2].limits.query)
+	}
+
+	p, err = loadProfile(writeProfile(t, dir, "profile.json", fixtureProfileJSON))
+	if err != nil {
+		t.Fatalf("loadProfile() error = %v", err)
+	}
+	if p.Loc != "UTC" || p.Output.Prefix != "ob-search" {
+		t.Errorf("loadProfile() = %+v, expected the default loc and prefix", p)
+	}
+	if _, _, _, searches, err = p.validate(); err != nil || len(searches) != 1 {
+		t.Errorf("validate() = %v, %v", searches, err)
+	} else if searches[0].limits.searchWindow() != search.DefaultWindow {
+		t.Errorf("validate() window = %v, expected: %v", searches[0].limits.searchWindow(), search.DefaultWindow)
+	}
+}
+
+func TestProfile_validate_unlimitedWindow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, window := range []string{"window: {buckets: 0, pips: 0}", "window: {}"} {
+		p, err := loadProfile(writeProfile(t, dir, "profile.yaml", "instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\n"+window+"\nsearches: [{stop-order: [0.5]}]"))
+		if err != nil {
+			t.Fatalf("#%d loadProfile() error = %v", i, err)
+		}
+		_, _, _, searches, err := p.validate()
+		if err != nil {
+			t.Fatalf("#%d validate() error = %v", i, err)
+		}
+		if expected := (search.Window{}); searches[0].limits.searchWindow() != expected {
+			t.Errorf("#%d validate() window = %v, expected: %v", i, searches[0].limits.searchWindow(), expected)
+		}
+	}
+}
+
+func TestProfile_validate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{"period: 2020/10/01-2020/10/02\nsearches: [{stop-order: [0.5]}]", "instrument is required"},
+		{"instruments: [USD_JPY]\nsearches: [{stop-order: [0.5]}]", "period is required"},
+		{"instruments: [USD_XXX]\nperiod: 2020/10/01-2020/10/02\nsearches: [{stop-order: [0.5]}]", "invalid instrument: USD_XXX"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nloc: PST\nsearches: [{stop-order: [0.5]}]", "invalid time location: PST"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02", "at least one search is required"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nwindow: {buckets: -1}\nsearches: [{stop-order: [0.5]}]", "invalid window: buckets and pips must not be negative"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nreport: first\nsearches: [{stop-order: [0.5]}]", "invalid report: first (nearest, all or overlapping)"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nsearches: [{name: a}]", "invalid search a: at least one of"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nsearches: [{name: a, stop-order: [0.5]}, {stop-order: [0.5]}]", "name of searches[1] is required"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nsearches: [{name: a, stop-order: [0.5]}, {name: a, stop-order: [0.5]}]", "duplicated search name: a"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nsearches: [{name: a/b, stop-order: [0.5]}, {name: c, stop-order: [0.5]}]", "invalid name of searches[0]"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nsearches: [{name: a, stop-order: [0.5], combine: xor}]", "invalid search a: invalid combine: xor"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nsearches: [{name: a, stop-order: [0.5], not: [stop-order]}]", "invalid search a: not requires combine"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nsearches: [{name: a, stop-order: [0.5], combine: and, not: [limit-order]}]", "invalid search a: invalid not: limit-order is not searched"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nsearches: [{name: a, query: 'order.short[0] >'}]", "invalid search a: invalid query: column 17"},
+		{"instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nsearches: [{name: a, stop-order: [0.5, -0.5]}]", "invalid search a: invalid stop-order: negative limit -0.5 requires net-amount"},
+	}
+	for i, tt := range tests {
+		p, err := loadProfile(writeProfile(t, dir, "profile.yaml", tt.body))
+		if err != nil {
+			t.Fatalf("#%d loadProfile() error = %v", i, err)
+		}
+		_, _, _, _, err = p.validate()
+		if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("#%d validate() error = %v, expected: %v", i, err, tt.expected)
+		}
+	}
+
+	// negative limits are valid for net amounts
+	p, err := loadProfile(writeProfile(t, dir, "profile.yaml", "instruments: [USD_JPY]\nperiod: 2020/10/01-2020/10/02\nnet-amount: true\nsearches: [{stop-order: [-0.5]}]"))
+	if err != nil {
+		t.Fatalf("loadProfile() error = %v", err)
+	}
+	if _, _, _, searches, err := p.validate(); err != nil || !searches[0].limits.net {
+		t.Errorf("validate() = %v, %v, expected a search of net amounts", searches, err)
+	}
+
+	// unknown fields are rejected
+	if _, err := loadProfile(writeProfile(t, dir, "profile.yaml", "instrument: USD_JPY")); err == nil {
+		t.Errorf("loadProfile() error = nil, expected an error of the unknown field")
+	}
+}
+
+func TestScan_namedSearches(t *testing.T) {
+	srv := newFixtureServer()
+	defer srv.Close()
+	client := oanda.NewClient("key", oanda.WithBaseURL(srv.URL), oanda.WithRetryPolicy(oanda.NoRetry))
+
+	searches := []namedSearch{
+		{name: "stop", limits: searchLimits{stopOrder: []float64{0.5, 1.0}}},
+		{name: "limit", limits: searchLimits{limitOrder: []float64{0.5}}},
+		{name: "any", limits: searchLimits{stopOrder: []float64{0.5, 1.0}, limitOrder: []float64{0.5}, combine: "or"}},
+	}
+	var actual []string
+	scan(context.Background(), client, []oanda.Instrument{oanda.InstrumentUSDJPY}, fixtureSince, fixtureSince.Add(time.Hour), searches, 4, func(_ time.Time, matches []search.Match) {
+		for _, m := range matches {
+			actual = append(actual, m.Search+":"+strings.Join(m.Conditions, " "))
+		}
+	})
+	expected := []string{"stop:stop-order", "any:stop-order", "limit:limit-order", "any:limit-order"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("scan() = %v, expected: %v", actual, expected)
+	}
+}
+
+func TestParseLowerLimits(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected []float64
+		isErr    bool
+	}{
+		{"", nil, false},
+		{"0.8", []float64{0.8}, false},
+		{"0.8-1.0", []float64{0.8, 1.0}, false},
+		{"-0.3", []float64{-0.3}, false},
+		{"-0.3--0.5", []float64{-0.3, -0.5}, false},
+		{"0.3--0.5-1", []float64{0.3, -0.5, 1}, false},
+		{"0.8-", nil, true},
+		{"0.8---1", nil, true},
+		{"0.8-x", nil, true},
+	}
+	for i, tt := range tests {
+		actual, err := parseLowerLimits("stop-order", tt.str)
+		if (err != nil) != tt.isErr {
+			t.Errorf("#%d parseLowerLimits(%q) error = %v", i, tt.str, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("#%d parseLowerLimits(%q) = %v, expected: %v", i, tt.str, actual, tt.expected)
+		}
+	}
+}
+
+func TestParseInstruments(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected []oanda.Instrument
+		isErr    bool
+	}{
+		{"USD_JPY", []oanda.Instrument{oanda.InstrumentUSDJPY}, false},
+		{"USD_JPY, EUR_USD", []oanda.Instrument{oanda.InstrumentUSDJPY, oanda.InstrumentEURUSD}, false},
+		{"EUR_USD,USD_JPY,EUR_USD", []oanda.Instrument{oanda.InstrumentEURUSD, oanda.InstrumentUSDJPY}, false},
+		{"all", oanda.Instruments(), false},
+		{"EUR_GBP,all", append([]oanda.Instrument{oanda.InstrumentEURGBP}, oanda.Instruments()[:8]...), false},
+		{"", nil, true},
+		{"USD_JPY,", nil, true},
+		{"UNKNOWN", nil, true},
+	}
+	for i, tt := range tests {
+		actual, err := parseInstruments(tt.str)
+		if (err != nil) != tt.isErr {
+			t.Errorf("#%d parseInstruments(%q) error = %v", i, tt.str, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("#%d parseInstruments(%q) = %v, expected: %v", i, tt.str, actual, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/search"
+)
+
+// scan reads snapshots of books of instruments from since until until every 20 minutes and runs searches on them.
+// Snapshots are read by workers goroutines concurrently through the shared source, and
+// emit is called with the matches of every snapshot in the order of time.
+// It stops when ctx is done without emitting the snapshots after the first unfinished one,
+// so that the emitted matches are always complete up to the last emitted snapshot.
+func scan(ctx context.Context, source bookSource, instruments []oanda.Instrument, since, until time.Time, searches []namedSearch, workers int,
+	emit func(snapshot time.Time, matches []search.Match)) {
+	type job struct {
+		i int
+		t time.Time
+	}
+	type result struct {
+		i       int
+		t       time.Time
+		matches []search.Match
+		ok      bool
+	}
+
+	jobs := make(chan job)
+	go func() {
+		defer close(jobs)
+		const twentyMinutes = 20 * time.Minute
+		i := 0
+		for t := since; t.Before(until); t = t.Add(twentyMinutes) {
+			select {
+			case jobs <- job{i, t}:
+				i++
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				matches, ok := scanSnapshot(ctx, source, instruments, j.t, searches)
+				results <- result{j.i, j.t, matches, ok}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// reorder results by time
+	pending := map[int]result{}
+	next := 0
+	for r := range results {
+		pending[r.i] = r
+		for {
+			p, ok := pending[next]
+			if !ok || !p.ok {
+				break
+			}
+			delete(pending, next)
+			emit(p.t, p.matches)
+			next++
+		}
+	}
+}
+
+// scanSnapshot reads the snapshot of each instrument at t and runs searches on them.
+// ok is false if ctx is done before the snapshot is finished.
+// Snapshots failed to be read are logged and skipped.
+func scanSnapshot(ctx context.Context, source bookSource, instruments []oanda.Instrument, t time.Time, searches []namedSearch) (matches []search.Match, ok bool) {
+	for _, instrument := range instruments {
+		orderBook, err := source.FetchOrderBookContext(ctx, instrument, &t)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, false
+			}
+			logFetchError("order book", instrument, t, err)
+			continue
+		}
+		positionBook, err := source.FetchPositionBookContext(ctx, instrument, &t)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, false
+			}
+			logFetchError("position book", instrument, t, err)
+			continue
+		}
+		ms, err := searchBooks(orderBook, positionBook, searches)
+		if err != nil {
+			log.Printf("failed to search books of %s (at %s): %v", instrument, t.String(), err)
+			continue
+		}
+		matches = append(matches, ms...)
+	}
+	return matches, true
+}
+
+// logFetchError logs the book of the snapshot which is skipped since it failed to be read.
+// A book missing from the archive is not an error but a snapshot the archive does not have.
+func logFetchError(book string, instrument oanda.Instrument, t time.Time, err error) {
+	if errors.Is(err, errNotArchived) {
+		log.Printf("skipped %s of %s (at %s): %v", book, instrument, t.String(), err)
+		return
+	}
+	log.Printf("failed to fetch %s of %s (at %s): %v", book, instrument, t.String(), err)
+}
+
+// searchBooks runs searches on the books. The matches record the name of the search.
+func searchBooks(orderBook, positionBook *oanda.Book, searches []namedSearch) ([]search.Match, error) {
+	var matches []search.Match
+	for _, s := range searches {
+		ms, err := search.Search(orderBook, positionBook, s.limits.searchWindow(), s.limits.conditions()...)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range ms {
+			m.Search = s.name
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+// delayedSource builds books every of whose snapshots has a stop order cluster below price.
+// Earlier snapshots take longer so that they finish after later ones.
+type delayedSource struct {
+	since time.Time
+	mu    sync.Mutex
+	now   int
+	max   int
+}
+
+func (s *delayedSource) book(ctx context.Context, dateTime *time.Time) (*oanda.Book, error) {
+	s.mu.Lock()
+	s.now++
+	if s.now > s.max {
+		s.max = s.now
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.now--
+		s.mu.Unlock()
+	}()
+
+	i := int(dateTime.Sub(s.since) / (20 * time.Minute))
+	select {
+	case <-time.After(time.Duration(10-i) * 2 * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	b := &oanda.Book{Instrument: oanda.InstrumentUSDJPY, Time: *dateTime, Price: 100.001}
+	for j := -25; j < 25; j++ {
+		b.Buckets = append(b.Buckets, oanda.BookBucket{Price: oanda.Price(100 + float64(j)*0.05)})
+	}
+	b.Buckets[24].ShortCountPercent = 1 // 100.00 is the nearest bucket below price
+	return b, nil
+}
+
+func (s *delayedSource) FetchOrderBookContext(ctx context.Context, instrument oanda.Instrument, dateTime *time.Time) (*oanda.Book, error) {
+	return s.book(ctx, dateTime)
+}
+
+func (s *delayedSource) FetchPositionBookContext(ctx context.Context, instrument oanda.Instrument, dateTime *time.Time) (*oanda.Book, error) {
+	return s.book(ctx, dateTime)
+}
+
+func TestScan_workers(t *testing.T) {
+	since := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	for _, workers := range []int{1, 4} {
+		source := &delayedSource{since: since}
+		matches := scanAll(context.Background(), source, []oanda.Instrument{oanda.InstrumentUSDJPY}, since, since.Add(10*20*time.Minute), searchLimits{stopOrder: []float64{0.5}}, workers)
+		if len(matches) != 10 {
+			t.Fatalf("workers=%d scan() = %d matches, want 10", workers, len(matches))
+		}
+		for i, m := range matches {
+			if expected := since.Add(time.Duration(i) * 20 * time.Minute); !m.Time.Equal(expected) {
+				t.Errorf("workers=%d scan()[%d] = %s, want %s", workers, i, m.Time, expected)
+			}
+		}
+		if source.max > workers {
+			t.Errorf("workers=%d scan() read %d snapshots at once", workers, source.max)
+		}
+		if workers > 1 && source.max < 2 {
+			t.Errorf("workers=%d scan() did not read snapshots concurrently", workers)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/archive"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+// bookSource provides snapshots of books to scan. It is implemented by oanda.Client
+// and archiveSource.
+type bookSource interface {
+	FetchOrderBookContext(ctx context.Context, instrument oanda.Instrument, dateTime *time.Time) (*oanda.Book, error)
+	FetchPositionBookContext(ctx context.Context, instrument oanda.Instrument, dateTime *time.Time) (*oanda.Book, error)
+}
+
+// errNotArchived is returned by archiveSource for a snapshot which is not in the archive.
+var errNotArchived = errors.New("not in the archive")
+
+// archiveSource reads snapshots from an archive built by the fetch subcommand instead of OANDA API.
+type archiveSource struct {
+	archive *archive.Archive
+}
+
+func (s archiveSource) FetchOrderBookContext(ctx context.Context, instrument oanda.Instrument, dateTime *time.Time) (*oanda.Book, error) {
+	return s.read(ctx, oanda.OrderBook, instrument, dateTime)
+}
+
+func (s archiveSource) FetchPositionBookContext(ctx context.Context, instrument oanda.Instrument, dateTime *time.Time) (*oanda.Book, error) {
+	return s.read(ctx, oanda.PositionBook, instrument, dateTime)
+}
+
+func (s archiveSource) read(ctx context.Context, kind oanda.BookKind, instrument oanda.Instrument, dateTime *time.Time) (*oanda.Book, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if dateTime == nil {
+		return nil, fmt.Errorf("failed to read %s: the archive has no latest snapshot", kind)
+	}
+	body, err := s.archive.Read(instrument, kind, *dateTime)
+	if os.IsNotExist(err) {
+		return nil, errNotArchived
+	}
+	if err != nil {
+		return nil, err
+	}
+	return oanda.ParseBook(kind, body)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/archive"
+	"github.com/yuki-inoue-eng/order-book-searcher/lib/oanda"
+)
+
+func TestArchiveSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	at := time.Date(2020, 10, 1, 0, 20, 0, 0, time.UTC)
+	a := archive.New(dir)
+	body := []byte(`{"orderBook":{"instrument":"USD_JPY","time":"2020-10-01T00:20:00Z","price":"105.512","bucketWidth":"0.050",` +
+		`"buckets":[{"price":"105.500","longCountPercent":"0.3","shortCountPercent":"0.2"}]}}`)
+	if err := a.Write(oanda.InstrumentUSDJPY, oanda.OrderBook, at, body); err != nil {
+		t.Fatal(err)
+	}
+	source := archiveSource{a}
+
+	book, err := source.FetchOrderBookContext(context.Background(), oanda.InstrumentUSDJPY, &at)
+	if err != nil {
+		t.Fatalf("FetchOrderBookContext() error = %v", err)
+	}
+	if !book.Time.Equal(at) || book.Price != 105.512 {
+		t.Errorf("FetchOrderBookContext() = %+v", book)
+	}
+	if _, err := source.FetchPositionBookContext(context.Background(), oanda.InstrumentUSDJPY, &at); !errors.Is(err, errNotArchived) {
+		t.Errorf("FetchPositionBookContext() error = %v, expected: %v", err, errNotArchived)
+	}
+	// the archive has no latest snapshot
+	if _, err := source.FetchOrderBookContext(context.Background(), oanda.InstrumentUSDJPY, nil); err == nil || errors.Is(err, errNotArchived) {
+		t.Errorf("FetchOrderBookContext(nil) error = %v, expected an error of the time", err)
+	}
+}